@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"forza/models"
@@ -24,9 +25,17 @@ type masterOut struct {
 	RelS    float64 `json:"relS"`
 	X       float64 `json:"x"`
 	Y       float64 `json:"y"`
+	Spread  float64 `json:"spread,omitempty"`
 	Surface string  `json:"surface,omitempty"`
 }
 
+// trackOut reports which stored track definition the session was mapped onto.
+type trackOut struct {
+	Name  string  `json:"name,omitempty"`
+	File  string  `json:"file,omitempty"`
+	Score float64 `json:"score,omitempty"` // fingerprint distance; 0 = identical
+}
+
 type heatOut struct {
 	Index    int     `json:"index"`
 	RelS     float64 `json:"relS"`
@@ -51,6 +60,9 @@ type eventOut struct {
 	MasterY    float64 `json:"masterY,omitempty"`
 	DistanceSq float64 `json:"distanceSq,omitempty"`
 	RacePos    int     `json:"racePosition,omitempty"`
+	Duration   float64 `json:"duration,omitempty"` // seconds, for interval events
+	Wheel      string  `json:"wheel,omitempty"`
+	Severity   string  `json:"severity,omitempty"`
 }
 
 type carPoint struct {
@@ -84,6 +96,16 @@ type carPoint struct {
 	TireTempRL    float64 `json:"tireTempRL,omitempty"`
 	TireTempRR    float64 `json:"tireTempRR,omitempty"`
 	DistToLine    float64 `json:"distToLine,omitempty"`
+	// Geometric match onto the master track.
+	Lateral    float64 `json:"lateral,omitempty"`    // meters, positive = left of master line
+	HeadingErr float64 `json:"headingErr,omitempty"` // radians vs master heading
+	// Balance is the handling balance, -1 (oversteer) .. 1 (understeer).
+	Balance float64 `json:"balance,omitempty"`
+	// Pedals is set when Throttle and Brake come from the pedal channels.
+	Pedals bool `json:"-"`
+	// X, Y is the driven position in the master frame; kept for reference files.
+	X float64 `json:"-"`
+	Y float64 `json:"-"`
 }
 
 type carOut struct {
@@ -95,19 +117,241 @@ type carOut struct {
 	CornersLap  []cornerLapStatOut  `json:"cornersLap,omitempty"`
 	Segments    []segmentStatOut    `json:"segments,omitempty"`
 	SegmentsLap []segmentLapStatOut `json:"segmentsLap,omitempty"`
+	Overrides   *track.LapOverrides `json:"overrides,omitempty"`
+	DeltaRef    string              `json:"deltaRef,omitempty"` // what Delta/TimeDelta compare against
+	BrakeZones  []brakeZoneOut      `json:"brakeZones,omitempty"`
+	// Throttle use averaged over laps, and per lap.
+	Throttle     *throttleOut          `json:"throttle,omitempty"`
+	ThrottleLaps []track.ThrottleStats `json:"throttleLaps,omitempty"`
+	// Lock-up and wheelspin counts per wheel over the whole session.
+	Lockups    map[string]int `json:"lockups,omitempty"`
+	Wheelspins map[string]int `json:"wheelspins,omitempty"`
+	// Steering input use averaged over laps, and per lap.
+	Steering     *steeringOut          `json:"steering,omitempty"`
+	SteeringLaps []track.SteeringStats `json:"steeringLaps,omitempty"`
+	// Handbrake pulls (count and total seconds) and clutch kicks over the session.
+	HandbrakePulls int     `json:"handbrakePulls,omitempty"`
+	HandbrakeTime  float64 `json:"handbrakeTime,omitempty"`
+	ClutchKicks    int     `json:"clutchKicks,omitempty"`
+	// Shifts out of each gear against the optimal upshift RPM.
+	Gears []gearOut `json:"gears,omitempty"`
+	// Engine curve rebuilt from full-throttle samples, and time per RPM bin per gear.
+	Dyno         *dynoOut     `json:"dyno,omitempty"`
+	RPMHistogram []gearRPMOut `json:"rpmHistogram,omitempty"`
+	// Estimated gear ratios, tyre radius and speed in each gear.
+	Gearbox *gearboxOut `json:"gearbox,omitempty"`
+	// Launch from the race start signal, when the session has one.
+	Start *startOut `json:"start,omitempty"`
+	// Speed trap readings: best and average per trap, and every lap's reading.
+	SpeedTraps    []trapStatOut `json:"speedTraps,omitempty"`
+	SpeedTrapLaps []trapLapOut  `json:"speedTrapLaps,omitempty"`
+}
+
+// speedTrapOut is a speed trap (or zone, with EndS) on the master, with its marker
+// position and the fastest reading of any car and lap.
+type speedTrapOut struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name,omitempty"`
+	Zone       bool    `json:"zone,omitempty"`
+	Auto       bool    `json:"auto,omitempty"`
+	RelS       float64 `json:"relS"`
+	EndS       float64 `json:"endS,omitempty"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	EndX       float64 `json:"endX,omitempty"`
+	EndY       float64 `json:"endY,omitempty"`
+	BestMPH    float64 `json:"bestMPH,omitempty"`
+	BestKMH    float64 `json:"bestKMH,omitempty"`
+	BestSource string  `json:"bestSource,omitempty"`
+	BestLap    int     `json:"bestLap,omitempty"`
+}
+
+// trapStatOut is a car's readings at one trap. Rank orders the cars by best reading.
+type trapStatOut struct {
+	ID      string  `json:"id"`
+	Laps    int     `json:"laps"`
+	BestMPH float64 `json:"bestMPH"`
+	BestKMH float64 `json:"bestKMH"`
+	BestLap int     `json:"bestLap"`
+	AvgMPH  float64 `json:"avgMPH"`
+	AvgKMH  float64 `json:"avgKMH"`
+	Rank    int     `json:"rank"`
+}
+
+// trapLapOut is one lap's reading at a trap. Rank orders every car's laps at the trap.
+type trapLapOut struct {
+	ID   string  `json:"id"`
+	Lap  int     `json:"lap"`
+	MPH  float64 `json:"mph"`
+	KMH  float64 `json:"kmh"`
+	Rank int     `json:"rank"`
+}
+
+// startOut is a car's race start. Durations are seconds from the start signal (zero
+// when not reached); Time is the signal's session time. Gained counts places gained
+// within Window seconds (negative when lost).
+type startOut struct {
+	Time          float64 `json:"time"`
+	Reaction      float64 `json:"reaction,omitempty"`     // unset without the throttle channel
+	ThrottleHeld  bool    `json:"throttleHeld,omitempty"` // already on the throttle at the signal
+	MoveTime      float64 `json:"moveTime"`
+	LaunchRPM     float64 `json:"launchRPM,omitempty"`
+	Wheelspins    int     `json:"wheelspins,omitempty"`
+	WheelspinTime float64 `json:"wheelspinTime,omitempty"`
+	To100KMH      float64 `json:"to100KMH,omitempty"`
+	To200KMH      float64 `json:"to200KMH,omitempty"`
+	StartPos      int     `json:"startPosition,omitempty"`
+	EndPos        int     `json:"endPosition,omitempty"`
+	Gained        int     `json:"gained"`
+	Window        float64 `json:"window"`
+}
+
+// gearboxOut is a car's estimated gearing. Ratios are overall (gear times final
+// drive). Top speeds are at the redline; ReachedPct is the highest RPM seen in the gear
+// as a share of the redline, so a gear the track never uses fully stands out.
+type gearboxOut struct {
+	Redline     float64        `json:"redline,omitempty"`
+	TireRadiusM float64        `json:"tireRadiusM,omitempty"`
+	MaxMPH      float64        `json:"maxMPH"` // fastest seen in the session
+	MaxKMH      float64        `json:"maxKMH"`
+	Gears       []gearRatioOut `json:"gears"`
+}
+
+type gearRatioOut struct {
+	Gear       int     `json:"gear"`
+	Ratio      float64 `json:"ratio,omitempty"`
+	Step       float64 `json:"step,omitempty"`    // ratio over the previous gear's
+	RPMDrop    float64 `json:"rpmDrop,omitempty"` // into the next gear from the redline
+	TopMPH     float64 `json:"topMPH,omitempty"`
+	TopKMH     float64 `json:"topKMH,omitempty"`
+	MaxRPM     float64 `json:"maxRPM"`
+	ReachedPct float64 `json:"reachedPct,omitempty"`
+}
+
+// dynoOut is a car's engine curve. The power band is the RPM range making at least
+// 90% of peak power.
+type dynoOut struct {
+	PeakKW        float64        `json:"peakKW"`
+	PeakHP        float64        `json:"peakHP"`
+	PeakPowerRPM  float64        `json:"peakPowerRPM"`
+	PeakNm        float64        `json:"peakNm"`
+	PeakLbFt      float64        `json:"peakLbFt"`
+	PeakTorqueRPM float64        `json:"peakTorqueRPM"`
+	BandLow       float64        `json:"bandLow"`
+	BandHigh      float64        `json:"bandHigh"`
+	Curve         []dynoPointOut `json:"curve"`
+}
+
+type dynoPointOut struct {
+	RPM     float64 `json:"rpm"`
+	KW      float64 `json:"kw"`
+	HP      float64 `json:"hp"`
+	Nm      float64 `json:"nm"`
+	LbFt    float64 `json:"lbft"`
+	Samples int     `json:"samples"`
+}
+
+// gearRPMOut is the time (s) spent per RPM bin in one gear. Bin RPM is the lower edge.
+type gearRPMOut struct {
+	Gear     int         `json:"gear"`
+	Time     float64     `json:"time"`
+	BandTime float64     `json:"bandTime"`
+	BandPct  float64     `json:"bandPct"`
+	Bins     []rpmBinOut `json:"bins"`
+}
+
+type rpmBinOut struct {
+	RPM  float64 `json:"rpm"`
+	Time float64 `json:"time"`
+}
+
+// gearOut summarises the shifts out of one gear. ShiftRPM is the average upshift RPM
+// and OptimalRPM the RPM that keeps the most power through the shift.
+type gearOut struct {
+	Gear         int     `json:"gear"`
+	Upshifts     int     `json:"upshifts"`
+	Downshifts   int     `json:"downshifts"`
+	ShiftRPM     float64 `json:"shiftRPM,omitempty"`
+	OptimalRPM   float64 `json:"optimalRPM,omitempty"`
+	DownshiftRPM float64 `json:"downshiftRPM,omitempty"`
+	TorqueGap    float64 `json:"torqueGap,omitempty"` // seconds per upshift
+	SpeedDropMPH float64 `json:"speedDropMPH,omitempty"`
+	SpeedDropKMH float64 `json:"speedDropKMH,omitempty"`
+	EarlyShifts  int     `json:"earlyShifts,omitempty"`
+	LateShifts   int     `json:"lateShifts,omitempty"`
+}
+
+// steeringOut summarises a car's steering: reversal rate and smoothness over all laps,
+// plus per-lap averages of corrections, sawing and counter-steer.
+type steeringOut struct {
+	Laps                int     `json:"laps"`
+	ReversalRate        float64 `json:"reversalRate"` // per minute
+	Smoothness          float64 `json:"smoothness"`   // 0..100
+	RateRMS             float64 `json:"rateRMS"`      // lock/s
+	CorrectionsPerLap   float64 `json:"correctionsPerLap"`
+	SawingPerLap        float64 `json:"sawingPerLap"` // seconds
+	CounterSteersPerLap float64 `json:"counterSteersPerLap"`
+}
+
+// throttleOut summarises a car's throttle use as shares of lap time plus per-lap averages.
+type throttleOut struct {
+	Laps         int     `json:"laps"`
+	FullPct      float64 `json:"fullPct"`
+	PartialPct   float64 `json:"partialPct"`
+	CoastPct     float64 `json:"coastPct"`
+	BrakePct     float64 `json:"brakePct"`
+	FullDist     float64 `json:"fullDist"`  // meters per lap
+	CoastDist    float64 `json:"coastDist"` // meters per lap
+	LiftsPerLap  float64 `json:"liftsPerLap"`
+	CoastsPerLap float64 `json:"coastsPerLap"`
+	PickupDelay  float64 `json:"pickupDelay,omitempty"` // average over corners (s)
+}
+
+// brakeZoneOut is one lap's braking into a corner. VsOwnBest and VsField are meters
+// (positive = braked later) against the car's quickest pass through the corner and
+// the quickest pass of any car.
+type brakeZoneOut struct {
+	Corner       int     `json:"corner"`
+	ID           string  `json:"id,omitempty"`
+	Lap          int     `json:"lap"`
+	PointS       float64 `json:"pointS"`
+	ReleaseS     float64 `json:"releaseS"`
+	DistanceM    float64 `json:"distanceM"`
+	Duration     float64 `json:"duration"`
+	PeakPressure float64 `json:"peakPressure"`
+	AvgPressure  float64 `json:"avgPressure"`
+	TrailTime    float64 `json:"trailTime,omitempty"`
+	EntryMPH     float64 `json:"entryMPH,omitempty"`
+	MinMPH       float64 `json:"minMPH,omitempty"`
+	ScrubbedMPH  float64 `json:"scrubbedMPH,omitempty"`
+	EntryKMH     float64 `json:"entryKMH,omitempty"`
+	MinKMH       float64 `json:"minKMH,omitempty"`
+	ScrubbedKMH  float64 `json:"scrubbedKMH,omitempty"`
+	VsOwnBest    float64 `json:"vsOwnBest,omitempty"`
+	VsField      float64 `json:"vsField,omitempty"`
+	FieldRef     string  `json:"fieldRef,omitempty"`  // source:lap of the quickest pass
+	Judgement    string  `json:"judgement,omitempty"` // early_brake or late_brake
 }
 
 type cornerOut struct {
 	Index     int     `json:"index"`
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name,omitempty"`
 	StartS    float64 `json:"startS"`
 	EndS      float64 `json:"endS"`
 	ApexS     float64 `json:"apexS"`
 	Direction string  `json:"direction,omitempty"`
 	AngleDeg  float64 `json:"angleDeg,omitempty"`
+	// Type is hairpin, sweeper, kink, chicane, complex or corner.
+	Type       string  `json:"type,omitempty"`
+	RadiusM    float64 `json:"radiusM,omitempty"`
+	Apexes     int     `json:"apexes,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"` // 0..10
 }
 
 type cornerStatOut struct {
 	Corner   int     `json:"corner"`
+	ID       string  `json:"id,omitempty"`
 	Count    int     `json:"count"`
 	EntryMPH float64 `json:"entryMPH,omitempty"`
 	MinMPH   float64 `json:"minMPH,omitempty"`
@@ -115,54 +359,88 @@ type cornerStatOut struct {
 	EntryKMH float64 `json:"entryKMH,omitempty"`
 	MinKMH   float64 `json:"minKMH,omitempty"`
 	ExitKMH  float64 `json:"exitKMH,omitempty"`
+	// TimeDelta is the average time lost (positive) or gained vs the delta reference.
+	TimeDelta float64 `json:"timeDelta,omitempty"`
+	// BestLap is the lap with the quickest pass through the corner; BestPhases are its phases.
+	BestLap    int                 `json:"bestLap,omitempty"`
+	BestPhases *track.CornerPhases `json:"bestPhases,omitempty"`
+	// PickupDelay is the average time from minimum speed to throttle pickup (s).
+	PickupDelay float64 `json:"pickupDelay,omitempty"`
+	// Balance is the average handling balance (+ understeer, - oversteer); Handling
+	// classifies it as understeer, oversteer or neutral.
+	Balance  float64 `json:"balance,omitempty"`
+	Handling string  `json:"handling,omitempty"`
+	// Lock-ups and wheelspins attributed to this corner, counted per wheel.
+	Lockups    map[string]int `json:"lockups,omitempty"`
+	Wheelspins map[string]int `json:"wheelspins,omitempty"`
+	// Average steering smoothness (0..100) and reversal rate (per minute) in the corner.
+	Smoothness   float64 `json:"smoothness,omitempty"`
+	ReversalRate float64 `json:"reversalRate,omitempty"`
+	// Handbrake pulls, total handbrake seconds and clutch kicks in the corner.
+	HandbrakePulls int     `json:"handbrakePulls,omitempty"`
+	HandbrakeTime  float64 `json:"handbrakeTime,omitempty"`
+	ClutchKicks    int     `json:"clutchKicks,omitempty"`
 }
 
 type segmentDefOut struct {
-	Index  int     `json:"index"`
-	Type   string  `json:"type"` // corner or straight
-	StartS float64 `json:"startS"`
-	EndS   float64 `json:"endS"`
+	Index      int     `json:"index"`
+	Type       string  `json:"type"` // corner or straight
+	StartS     float64 `json:"startS"`
+	EndS       float64 `json:"endS"`
+	Corner     string  `json:"corner,omitempty"`     // corner ID
+	CornerType string  `json:"cornerType,omitempty"` // hairpin, sweeper, ...
 }
 
 type segmentStatOut struct {
-	Segment  int     `json:"segment"`
-	Type     string  `json:"type"`
-	Count    int     `json:"count"`
-	EntryMPH float64 `json:"entryMPH,omitempty"`
-	MinMPH   float64 `json:"minMPH,omitempty"`
-	ExitMPH  float64 `json:"exitMPH,omitempty"`
-	AvgMPH   float64 `json:"avgMPH,omitempty"`
-	Time     float64 `json:"time,omitempty"`
-	EntryKMH float64 `json:"entryKMH,omitempty"`
-	MinKMH   float64 `json:"minKMH,omitempty"`
-	ExitKMH  float64 `json:"exitKMH,omitempty"`
-	AvgKMH   float64 `json:"avgKMH,omitempty"`
+	Segment    int     `json:"segment"`
+	Type       string  `json:"type"`
+	CornerType string  `json:"cornerType,omitempty"`
+	Count      int     `json:"count"`
+	EntryMPH   float64 `json:"entryMPH,omitempty"`
+	MinMPH     float64 `json:"minMPH,omitempty"`
+	ExitMPH    float64 `json:"exitMPH,omitempty"`
+	AvgMPH     float64 `json:"avgMPH,omitempty"`
+	Time       float64 `json:"time,omitempty"`
+	EntryKMH   float64 `json:"entryKMH,omitempty"`
+	MinKMH     float64 `json:"minKMH,omitempty"`
+	ExitKMH    float64 `json:"exitKMH,omitempty"`
+	AvgKMH     float64 `json:"avgKMH,omitempty"`
 }
 
 type segmentLapStatOut struct {
-	Segment  int     `json:"segment"`
-	Lap      int     `json:"lap"`
-	Type     string  `json:"type"`
-	EntryMPH float64 `json:"entryMPH,omitempty"`
-	MinMPH   float64 `json:"minMPH,omitempty"`
-	ExitMPH  float64 `json:"exitMPH,omitempty"`
-	AvgMPH   float64 `json:"avgMPH,omitempty"`
-	Time     float64 `json:"time,omitempty"`
-	EntryKMH float64 `json:"entryKMH,omitempty"`
-	MinKMH   float64 `json:"minKMH,omitempty"`
-	ExitKMH  float64 `json:"exitKMH,omitempty"`
-	AvgKMH   float64 `json:"avgKMH,omitempty"`
+	Segment    int     `json:"segment"`
+	Lap        int     `json:"lap"`
+	Type       string  `json:"type"`
+	CornerType string  `json:"cornerType,omitempty"`
+	EntryMPH   float64 `json:"entryMPH,omitempty"`
+	MinMPH     float64 `json:"minMPH,omitempty"`
+	ExitMPH    float64 `json:"exitMPH,omitempty"`
+	AvgMPH     float64 `json:"avgMPH,omitempty"`
+	Time       float64 `json:"time,omitempty"`
+	EntryKMH   float64 `json:"entryKMH,omitempty"`
+	MinKMH     float64 `json:"minKMH,omitempty"`
+	ExitKMH    float64 `json:"exitKMH,omitempty"`
+	AvgKMH     float64 `json:"avgKMH,omitempty"`
 }
 
 type cornerLapStatOut struct {
-	Corner   int     `json:"corner"`
-	Lap      int     `json:"lap"`
-	EntryMPH float64 `json:"entryMPH,omitempty"`
-	MinMPH   float64 `json:"minMPH,omitempty"`
-	ExitMPH  float64 `json:"exitMPH,omitempty"`
-	EntryKMH float64 `json:"entryKMH,omitempty"`
-	MinKMH   float64 `json:"minKMH,omitempty"`
-	ExitKMH  float64 `json:"exitKMH,omitempty"`
+	Corner    int     `json:"corner"`
+	ID        string  `json:"id,omitempty"`
+	Lap       int     `json:"lap"`
+	EntryMPH  float64 `json:"entryMPH,omitempty"`
+	MinMPH    float64 `json:"minMPH,omitempty"`
+	ExitMPH   float64 `json:"exitMPH,omitempty"`
+	EntryKMH  float64 `json:"entryKMH,omitempty"`
+	MinKMH    float64 `json:"minKMH,omitempty"`
+	ExitKMH   float64 `json:"exitKMH,omitempty"`
+	TimeDelta float64 `json:"timeDelta,omitempty"`
+	// Phase locations (master relS) and times, and their difference to the best lap.
+	Phases   *track.CornerPhases  `json:"phases,omitempty"`
+	VsBest   *track.PhaseDelta    `json:"vsBest,omitempty"`
+	Throttle *track.ThrottleStats `json:"throttle,omitempty"`
+	Steering *track.SteeringStats `json:"steering,omitempty"`
+	Balance  float64              `json:"balance,omitempty"`
+	Handling string               `json:"handling,omitempty"`
 }
 
 func main() {
@@ -181,17 +459,120 @@ func main() {
 	sprintMode := flag.Bool("sprint", false, "Treat input as sprint (no lap crossing); if false, assume lapped race")
 	serve := flag.Bool("serve", true, "Generate JSON then serve the viewer locally")
 	addr := flag.String("addr", ":8080", "Listen address when -serve is enabled")
+	startFlag := flag.String("start", "", "Crop sessions to start at this time in seconds or lap (e.g. 90 or lap:3)")
+	endFlag := flag.String("end", "", "Crop sessions to end at this time in seconds or lap (e.g. 600 or lap:8)")
+	manifestPath := flag.String("manifest", "", "JSON manifest listing files with optional per-file start/end crop")
+	deltaRefSpec := flag.String("delta-ref", "own-best", "Delta reference: own-best, best, <source>:<lap> or a reference .json file")
+	referencePath := flag.String("reference", "", "Reference lap file to use as delta baseline and ghost car")
+	referenceOut := flag.String("reference-out", "", "Save a reference lap (see -reference-lap) to this file")
+	referenceLap := flag.String("reference-lap", "best", "Lap saved by -reference-out: best or <source>:<lap>")
+	masterOutPath := flag.String("master-out", "", "Save the master track definition (master, corners, segments, gates) to this file")
+	masterInPath := flag.String("master-in", "", "Load a saved master track definition instead of building one")
+	trackLibDir := flag.String("track-lib", "", "Folder of saved track definitions used to identify the track automatically")
+	trackMatchSpec := flag.String("track-match", "", "Library match thresholds <length>,<signature> (defaults 0.08,0.35); a stored track's own \"match\" wins")
+	trackName := flag.String("track-name", "", "Name stored with -master-out")
+	segmentTypes := flag.String("segment-types", "", "Comma-separated segment or corner types to include in segment statistics (e.g. straight,hairpin,chicane); empty = all")
+	startWindow := flag.Float64("start-window", 10, "Seconds after the race start signal over which places gained or lost are counted")
+	speedTrapSpec := flag.String("speed-traps", "", "Comma-separated speed traps (master relS, e.g. 1200) and zones (start-end, e.g. 2500-2900)")
+	mode := flag.String("mode", "race", "Analysis mode: race (track, laps and cars) or perf (standing-start acceleration runs, written as JSON)")
+	redetectCorners := flag.Bool("redetect-corners", false, "Re-detect corners on a stored track, keeping stored corner IDs and names by apex position")
 	flag.Parse()
 
+	var trackDef *track.TrackDef
+	if *masterInPath != "" {
+		def, err := loadTrackDef(*masterInPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading master track: %v\n", err)
+			os.Exit(1)
+		}
+		trackDef = def
+	}
+	trackMatch, err := track.ParseMatchThresholds(*trackMatchSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -track-match: %v\n", err)
+		os.Exit(1)
+	}
+	var trackLib []track.TrackDef
+	var trackLibFiles []string
+	if *trackLibDir != "" && trackDef == nil {
+		lib, files, err := loadTrackLibrary(*trackLibDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading track library: %v\n", err)
+			os.Exit(1)
+		}
+		trackLib, trackLibFiles = lib, files
+	}
+	var identified *trackOut
+	if trackDef != nil {
+		identified = &trackOut{Name: trackDef.Name, File: *masterInPath}
+	}
+
+	if *referencePath == "" && strings.EqualFold(filepath.Ext(*deltaRefSpec), ".json") {
+		*referencePath = *deltaRefSpec
+	}
+	var refFile *referenceFile
+	if *referencePath != "" {
+		rf, err := loadReferenceFile(*referencePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading reference: %v\n", err)
+			os.Exit(1)
+		}
+		refFile = rf
+	}
+
+	defaultWindow, err := parseCropWindow(*startFlag, *endFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid crop window: %v\n", err)
+		os.Exit(1)
+	}
+
+	userTraps, err := track.ParseSpeedTraps(*speedTrapSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -speed-traps: %v\n", err)
+		os.Exit(1)
+	}
+
+	segmentFilter := make(map[string]bool)
+	for _, t := range strings.Split(*segmentTypes, ",") {
+		if t = strings.TrimSpace(strings.ToLower(t)); t != "" {
+			segmentFilter[t] = true
+		}
+	}
+
 	// Collect input files from flags
 	inputFiles := append([]string{}, filePaths...)
 	if len(folderPaths) > 0 {
 		inputFiles = append(inputFiles, filesFromFolders(folderPaths)...)
 	}
+	windows := make(map[string]cropWindow)
+	if *manifestPath != "" {
+		entries, err := loadManifest(*manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading manifest: %v\n", err)
+			os.Exit(1)
+		}
+		for _, e := range entries {
+			inputFiles = append(inputFiles, e.path)
+			windows[e.path] = e.window
+		}
+	}
+	inputFiles = uniqueInputs(inputFiles, windows)
 	if len(inputFiles) == 0 {
 		fmt.Fprintf(os.Stderr, "no CSV files found; provide -file and/or -folder\n")
 		os.Exit(1)
 	}
+	switch *mode {
+	case "race":
+	case "perf":
+		if err := runPerf(inputFiles, windows, defaultWindow, *outPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -mode %q (want race or perf)\n", *mode)
+		os.Exit(1)
+	}
 	fmt.Fprintf(os.Stderr, "input files: %d\n", len(inputFiles))
 
 	var (
@@ -202,6 +583,10 @@ func main() {
 		masterTrack    []models.Trackpoint
 		sessions       []sessionResult
 		detectedSprint bool
+		// Sprint runs in world coordinates; the master is returned to the frame
+		// of the first aggregated session (frameX, frameY).
+		sprintRuns     [][]models.Trackpoint
+		frameX, frameY float64
 	)
 	allLapIdx = append(allLapIdx, 0)
 
@@ -216,6 +601,15 @@ func main() {
 				results <- sessionResult{path: p, err: fmt.Errorf("load: %w", err)}
 				return
 			}
+			window, ok := windows[p]
+			if !ok {
+				window = defaultWindow
+			}
+			samples, err = track.CropSamples(samples, window.start, window.end)
+			if err != nil {
+				results <- sessionResult{path: p, err: fmt.Errorf("crop: %w", err)}
+				return
+			}
 			telemetryLapIdx := track.LapIdxFromTelemetry(samples)
 			tp, err := track.BuildTrack(samples)
 			if err != nil {
@@ -252,26 +646,61 @@ func main() {
 					if enforce && len(lapIdx) == 2 && laps > 1 {
 						lapIdx = track.BuildEvenLapIdx(tp, laps)
 					}
+					// A stored start/finish gate beats the session's own start point.
+					if trackDef != nil && !trackDef.Sprint {
+						if gateIdx, ok := gateLaps(samples, tp, trackDef, *minLapSpacing); ok {
+							lapIdx = gateIdx
+						}
+					}
 				}
 			} else {
 				lapIdx = []int{0, len(tp)}
 			}
+			overrides, err := loadLapOverrides(p)
+			if err != nil {
+				results <- sessionResult{path: p, err: fmt.Errorf("overrides: %w", err)}
+				return
+			}
+			lapIdx, lapTypes, err := finishLaps(samples, tp, lapIdx, overrides)
+			if err != nil {
+				results <- sessionResult{path: p, err: fmt.Errorf("overrides: %w", err)}
+				return
+			}
+			originX, originY := track.TrackOrigin(samples)
 			results <- sessionResult{
-				path:    p,
-				track:   tp,
-				samples: samples,
-				events:  events,
-				race:    raceType,
-				lapIdx:  lapIdx,
-				dist:    sessionDist,
-				dur:     sessionTime,
+				path:      p,
+				window:    window,
+				track:     tp,
+				samples:   samples,
+				events:    events,
+				race:      raceType,
+				gateable:  raceType == "lapped" && telemetryLapIdx == nil,
+				lapIdx:    lapIdx,
+				lapTypes:  lapTypes,
+				overrides: overrides,
+				originX:   originX,
+				originY:   originY,
+				dist:      sessionDist,
+				dur:       sessionTime,
 			}
 		}(path)
 	}
 	wg.Wait()
 	close(results)
 
+	// Keep input order so the master frame and car order do not depend on which
+	// session finished loading first.
+	order := make(map[string]int, len(inputFiles))
+	for i, p := range inputFiles {
+		order[p] = i
+	}
+	var loaded []sessionResult
 	for res := range results {
+		loaded = append(loaded, res)
+	}
+	sort.SliceStable(loaded, func(i, j int) bool { return order[loaded[i].path] < order[loaded[j].path] })
+
+	for _, res := range loaded {
 		if res.err != nil {
 			fmt.Fprintf(os.Stderr, "error %s: %v\n", res.path, res.err)
 			continue
@@ -285,18 +714,41 @@ func main() {
 			detectedSprint = true
 		}
 
+		// Only flying laps feed the master lap unless the session has none.
+		hasFlying := false
+		for _, lt := range res.lapTypes {
+			if lt == track.LapFlying {
+				hasFlying = true
+				break
+			}
+		}
 		for i := 0; i < len(res.lapIdx)-1; i++ {
-			seg := res.track[res.lapIdx[i]:res.lapIdx[i+1]]
+			if i < len(res.lapTypes) && res.lapTypes[i] == track.LapExcluded {
+				continue
+			}
+			if hasFlying && i < len(res.lapTypes) && res.lapTypes[i] != track.LapFlying {
+				continue
+			}
+			if lapsAdded == 0 {
+				frameX, frameY = res.originX, res.originY
+			}
+			// Move every lap into the first session's frame so laps overlap spatially.
+			seg := track.Translate(res.track[res.lapIdx[i]:res.lapIdx[i+1]], res.originX-frameX, res.originY-frameY)
 			allPoints = append(allPoints, seg...)
 			allLapIdx = append(allLapIdx, len(allPoints))
 			lapsAdded++
 			// In sprint mode, we only want a single pass; break once added.
 			if *sprintMode || res.race == "sprint" {
+				sprintRuns = append(sprintRuns, track.Translate(seg, frameX, frameY))
 				break
 			}
 		}
 
-		sessionLogs = append(sessionLogs, fmt.Sprintf("%s laps=%d dist=%.1fm time=%.1fs events=%d", res.path, len(res.lapIdx)-1, res.dist, res.dur, len(res.events)))
+		logLine := fmt.Sprintf("%s laps=%d dist=%.1fm time=%.1fs events=%d", res.path, len(res.lapIdx)-1, res.dist, res.dur, len(res.events))
+		if res.window.start.Set || res.window.end.Set {
+			logLine += fmt.Sprintf(" crop=%s..%s", res.window.start, res.window.end)
+		}
+		sessionLogs = append(sessionLogs, logLine)
 		sessions = append(sessions, res)
 	}
 
@@ -315,14 +767,17 @@ func main() {
 
 	effectiveSprint := *sprintMode || detectedSprint
 
-	if effectiveSprint {
+	if trackDef != nil {
+		masterTrack = trackDef.MasterPoints(frameX, frameY)
+		fmt.Fprintf(os.Stderr, "using stored master track %s (%d points)\n", *masterInPath, len(masterTrack))
+	} else if effectiveSprint {
 		if *useMaster {
-			master := track.BuildMasterPath(trackPoints, *masterSamples, false)
+			master := track.Translate(track.BuildMasterSprint(sprintRuns, *masterSamples), -frameX, -frameY)
 			if len(master) > 0 {
 				masterTrack = master
 				trackPoints = master
 				lapIdx = []int{0, len(master)}
-				fmt.Fprintf(os.Stderr, "using master sprint path (%d points)\n", len(master))
+				fmt.Fprintf(os.Stderr, "using master sprint path (%d points) from %d runs\n", len(master), len(sprintRuns))
 			}
 		}
 	} else {
@@ -349,55 +804,142 @@ func main() {
 		os.Exit(1)
 	}
 
-	cornerDefs := track.DetectCorners(masterTrack)
+	// Match the freshly built master against the track library.
+	if trackDef == nil && len(trackLib) > 0 {
+		fp := track.FingerprintOf(track.Translate(masterTrack, frameX, frameY), track.DetectCorners(masterTrack), effectiveSprint)
+		if i, score := track.IdentifyTrack(fp, trackLib, trackMatch); i >= 0 {
+			trackDef = &trackLib[i]
+			masterTrack = trackDef.MasterPoints(frameX, frameY)
+			identified = &trackOut{Name: trackDef.Name, File: trackLibFiles[i], Score: score}
+			fmt.Fprintf(os.Stderr, "identified track %q (%s, score %.3f)\n", trackDef.Name, trackLibFiles[i], score)
+			// Laps were split at each session's own start point; the stored master starts
+			// at the library gate, so split again there before mapping onto it.
+			if !trackDef.Sprint {
+				for k := range sessions {
+					sess := &sessions[k]
+					if !sess.gateable {
+						continue
+					}
+					gateIdx, ok := gateLaps(sess.samples, sess.track, trackDef, *minLapSpacing)
+					if !ok {
+						fmt.Fprintf(os.Stderr, "warning: %s does not cross the start/finish of %q, keeping its own laps\n", sess.path, trackDef.Name)
+						continue
+					}
+					lapIdx, lapTypes, err := finishLaps(sess.samples, sess.track, gateIdx, sess.overrides)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "warning: %s: overrides: %v, keeping its own laps\n", sess.path, err)
+						continue
+					}
+					sess.lapIdx, sess.lapTypes = lapIdx, lapTypes
+				}
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "track not found in library %s\n", *trackLibDir)
+		}
+	}
+
+	masterIndex := track.NewMasterIndex(masterTrack, 0)
+	var cornerDefs []track.CornerDef
+	var segmentDefs []track.SegmentDef
+	var sectorGates []float64
+	var storedCorners []track.CornerDef
+	if trackDef != nil {
+		storedCorners = append(storedCorners, trackDef.Corners...)
+		track.AnchorCorners(storedCorners, masterTrack, frameX, frameY)
+		sectorGates = trackDef.Sectors
+	}
+	if trackDef != nil && !*redetectCorners {
+		cornerDefs = append([]track.CornerDef(nil), storedCorners...)
+		track.ClassifyCorners(cornerDefs, masterTrack)
+		segmentDefs = trackDef.Segments
+	} else {
+		cornerDefs = track.DetectCorners(masterTrack)
+	}
+	// Corner IDs and names follow the apex position, not the detection order.
+	track.AnchorCorners(cornerDefs, masterTrack, frameX, frameY)
+	track.MatchCornerIDs(cornerDefs, storedCorners, 30)
+	if segmentDefs == nil {
+		segmentDefs = track.BuildSegments(masterTrack, cornerDefs)
+	} else {
+		track.TagSegments(segmentDefs, cornerDefs)
+	}
+	if len(sectorGates) < 2 {
+		sectorGates = track.EqualSectorGates(masterTrack, 3)
+	}
+	if trackDef != nil {
+		userTraps = append(append([]track.SpeedTrap(nil), trackDef.SpeedTraps...), userTraps...)
+	}
+	track.NumberSpeedTraps(userTraps)
+	if *masterOutPath != "" {
+		def := track.NewTrackDef(masterTrack, cornerDefs, segmentDefs, sectorGates, effectiveSprint, frameX, frameY, *startFinishRadius)
+		if trackDef != nil {
+			def.Name = trackDef.Name
+			def.StartFinish = trackDef.StartFinish
+		}
+		def.SpeedTraps = userTraps
+		if *trackName != "" {
+			def.Name = *trackName
+		}
+		if err := writeJSONFile(*masterOutPath, def); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing master track: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "wrote master track %s\n", *masterOutPath)
+	}
 	cornerOuts := make([]cornerOut, 0, len(cornerDefs))
 	for _, c := range cornerDefs {
 		cornerOuts = append(cornerOuts, cornerOut{
-			Index:     c.Index,
-			StartS:    c.StartS,
-			EndS:      c.EndS,
-			ApexS:     c.ApexS,
-			Direction: c.Direction,
-			AngleDeg:  c.AngleRad * 180 / math.Pi,
+			Index:      c.Index,
+			ID:         c.ID,
+			Name:       c.Name,
+			StartS:     c.StartS,
+			EndS:       c.EndS,
+			ApexS:      c.ApexS,
+			Direction:  c.Direction,
+			AngleDeg:   c.AngleRad * 180 / math.Pi,
+			Type:       c.Type,
+			RadiusM:    c.RadiusM,
+			Apexes:     c.Apexes,
+			Difficulty: c.Difficulty,
 		})
 	}
-	segmentDefs := buildSegments(masterTrack, cornerDefs)
 	segmentOuts := make([]segmentDefOut, 0, len(segmentDefs))
 	for i, s := range segmentDefs {
 		segmentOuts = append(segmentOuts, segmentDefOut{
-			Index:  i,
-			Type:   s.segType,
-			StartS: s.startS,
-			EndS:   s.endS,
+			Index:      i,
+			Type:       s.Type,
+			StartS:     s.StartS,
+			EndS:       s.EndS,
+			Corner:     s.Corner,
+			CornerType: s.CornerType,
 		})
 	}
 
-	// Add braking timing events (early/late) per session using master track reference.
-	for i := range sessions {
-		brakeEvents := track.DetectBrakeTiming(sessions[i].samples, sessions[i].track, sessions[i].lapIdx, masterTrack)
-		if len(brakeEvents) > 0 {
-			sessions[i].events = append(sessions[i].events, brakeEvents...)
-		}
-	}
-
 	out := struct {
 		Master   []masterOut     `json:"master"`
 		Corners  []cornerOut     `json:"corners,omitempty"`
 		Segments []segmentDefOut `json:"segments,omitempty"`
+		Sectors  []float64       `json:"sectors,omitempty"` // sector gates (master relS)
 		Heatmap  []heatOut       `json:"heatmap,omitempty"`
 		Events   []eventOut      `json:"events,omitempty"`
 		Cars     []carOut        `json:"cars,omitempty"`
 		RaceType string          `json:"raceType,omitempty"`
+		Track    *trackOut       `json:"track,omitempty"`
+		// Speed traps and zones as master markers, with the fastest reading.
+		SpeedTraps []speedTrapOut `json:"speedTraps,omitempty"`
 	}{}
+	out.Track = identified
 
 	for _, p := range masterTrack {
 		out.Master = append(out.Master, masterOut{
-			RelS: p.S,
-			X:    p.X,
-			Y:    p.Y,
+			RelS:   p.S,
+			X:      p.X,
+			Y:      p.Y,
+			Spread: p.Spread,
 		})
 	}
 	out.Segments = segmentOuts
+	out.Sectors = sectorGates
 	out.Corners = cornerOuts
 
 	// Parallel per-session processing for mapping/metrics/events.
@@ -441,10 +983,31 @@ func main() {
 			} else if sess.race == "lapped" {
 				res.lappedCount = 1
 			}
-			lapStartTime := make(map[int]float64)
-			lapLength := make(map[int]float64)
-			lapDeltaOffset := make(map[int]float64)
 			surfaceLabels := track.ClassifySurface(sess.samples, sess.track, 30)
+			balance := track.HandlingBalance(sess.samples)
+			sess.events = append(sess.events, track.DetectBalanceEvents(sess.samples, balance)...)
+			slips := track.DetectWheelSlip(sess.samples)
+			sess.events = append(sess.events, track.WheelSlipEvents(sess.samples, slips)...)
+			// A lap-override trim keeps only the laps' span; events and the start
+			// outside it are dropped along with the rest of the session.
+			lo, hi := 0, len(sess.samples)
+			if sess.overrides != nil && len(sess.lapIdx) >= 2 {
+				lo, hi = sess.lapIdx[0], sess.lapIdx[len(sess.lapIdx)-1]
+			}
+			if l, ok := track.DetectLaunch(sess.samples, slips, *startWindow); ok && l.Index >= lo && l.Index < hi {
+				sess.events = append(sess.events, track.LaunchEvents(sess.samples, l)...)
+				res.car.Start = startOf(l, sess.samples[0].Time)
+			}
+			sess.events = append(sess.events, track.DetectHandbrake(sess.samples)...)
+			sess.events = append(sess.events, track.DetectClutchKicks(sess.samples)...)
+			shifts := track.DetectShifts(sess.samples)
+			gears := track.GearSummary(sess.samples, shifts)
+			sess.events = append(sess.events, track.ShiftEvents(sess.samples, shifts, gears)...)
+			sess.events = append(sess.events, track.DetectLimiter(sess.samples)...)
+			sess.events = eventsInRange(sess.events, lo, hi)
+			res.car.Gears = gearsOut(gears)
+			res.car.Dyno, res.car.RPMHistogram = engineCurve(sess.samples)
+			res.car.Gearbox = gearboxOf(sess.samples)
 			lastSurface := ""
 			if len(surfaceLabels) > 0 {
 				lastSurface = surfaceLabels[0]
@@ -564,40 +1127,33 @@ func main() {
 				scaleNeg = scalePos
 			}
 			res.car.LapTimes = track.ComputeLapMetrics(sess.samples, sess.track, sess.lapIdx, 3)
-			bestSectors := bestSectorTimes(res.car.LapTimes)
+			for k := range res.car.LapTimes {
+				if lap := res.car.LapTimes[k].Lap; lap-1 < len(sess.lapTypes) {
+					res.car.LapTimes[k].Type = sess.lapTypes[lap-1]
+				}
+			}
+			res.car.Overrides = sess.overrides
+			// Session points moved into the master's frame for geometric map-matching.
+			mapTrack := track.Translate(sess.track, sess.originX-frameX, sess.originY-frameY)
+			projected := make(map[int]track.Projection)
 			for lapNum := 1; lapNum < len(sess.lapIdx); lapNum++ {
 				start := sess.lapIdx[lapNum-1]
 				end := sess.lapIdx[lapNum]
 				if start < 0 || end > len(sess.track) {
 					continue
 				}
-				segment := sess.track[start:end]
-				if len(segment) > 0 {
-					lapLength[lapNum] = segment[len(segment)-1].S - segment[0].S
-					if lapLength[lapNum] <= 0 {
-						lapLength[lapNum] = segment[len(segment)-1].S
-					}
-					lapStartTime[lapNum] = sess.samples[start].Time - sess.samples[0].Time
-					if lapStartTime[lapNum] < 0 {
-						lapStartTime[lapNum] = 0
-					}
-				}
-				masterLen := masterTrack[len(masterTrack)-1].S
-				lapLen := 0.0
-				if len(segment) > 0 {
-					lapLen = segment[len(segment)-1].S - segment[0].S
-				}
-				scaleS := 1.0
-				if lapLen > 0 && masterLen > 0 {
-					scaleS = masterLen / lapLen
+				if lapNum-1 < len(sess.lapTypes) && sess.lapTypes[lapNum-1] == track.LapExcluded {
+					continue
 				}
-				track.MapToMaster(segment, masterTrack, start, scaleS, func(idx int, relS, x, y float64, mi int, mRelS, mx, my, dist float64) {
+				track.ProjectToMaster(mapTrack[start:end], masterIndex, start, func(idx int, pr track.Projection) {
+					relS, mi, mx, my := pr.RelS, pr.Index, pr.X, pr.Y
+					projected[idx] = pr
 					var heading, speedMPH, speedKMH float64
 					var gear int
 					var t float64
 					var accel float64
 					var lngAcc, ltAcc, yr float64
-					var steer float64
+					var steer, bal float64
 					var suspFL, suspFR, suspRL, suspRR float64
 					var tempFL, tempFR, tempRL, tempRR float64
 					distToLine := 0.0
@@ -607,6 +1163,7 @@ func main() {
 					}
 					if idx >= 0 && idx < len(sess.samples) {
 						speedMPH = sess.samples[idx].SpeedMPH
+						bal = balance[idx]
 						speedKMH = sess.samples[idx].SpeedKMH
 						gear = sess.samples[idx].Gear
 						t = sess.samples[idx].Time - sess.samples[0].Time
@@ -653,7 +1210,7 @@ func main() {
 						// Forza provides norm_driving_line in approx -127..127; scale to -100..100.
 						distToLine = float64(sess.samples[idx].NormDrivingLine) / 127.0 * 100.0
 					} else {
-						distToLine = track.SignedDistanceAtRelS(masterTrack, mRelS, x, y)
+						distToLine = pr.Lateral
 					}
 					if speedMPH == 0 && speedKMH > 0 {
 						speedMPH = speedKMH * 0.621371
@@ -676,16 +1233,6 @@ func main() {
 							res.surfaceCounts[mi][surfaceLabels[idx]]++
 						}
 					}
-					delta := 0.0
-					if lapStart, ok := lapStartTime[lapNum]; ok {
-						elapsedLap := t - lapStart
-						expected := expectedTimeForProgress(bestSectors, lapLength[lapNum], relS)
-						delta = elapsedLap - expected
-						if _, seen := lapDeltaOffset[lapNum]; !seen {
-							lapDeltaOffset[lapNum] = delta
-						}
-						delta -= lapDeltaOffset[lapNum]
-					}
 					currentSurface := ""
 					if idx >= 0 && idx < len(surfaceLabels) {
 						currentSurface = surfaceLabels[idx]
@@ -723,6 +1270,7 @@ func main() {
 						throttle = math.Max(0, throttle)
 					}
 					var throttleInput, brakeInput, steerInput float64
+					pedals := false
 					if idx >= 0 && idx < len(sess.samples) {
 						sample := sess.samples[idx]
 						if sample.HasInputAccel || sample.HasInputBrake {
@@ -730,6 +1278,7 @@ func main() {
 							brakeInput = clamp01(float64(sample.Brake) / 255.0)
 							throttle = throttleInput
 							brake = brakeInput
+							pedals = true
 						}
 						if sample.HasInputSteer {
 							steerInput = clampSym(float64(sample.Steer) / 127.0)
@@ -745,10 +1294,11 @@ func main() {
 						Heading:       heading,
 						MasterX:       mx,
 						MasterY:       my,
+						X:             mapTrack[idx].X,
+						Y:             mapTrack[idx].Y,
 						SpeedMPH:      speedMPH,
 						SpeedKMH:      speedKMH,
 						Gear:          gear,
-						Delta:         delta,
 						LongAcc:       lngAcc,
 						LatAcc:        ltAcc,
 						YawRate:       yr,
@@ -759,6 +1309,7 @@ func main() {
 						ThrottleInput: throttleInput,
 						BrakeInput:    brakeInput,
 						SteerInput:    steerInput,
+						Pedals:        pedals,
 						SuspFL:        suspFL,
 						SuspFR:        suspFR,
 						SuspRL:        suspRL,
@@ -768,6 +1319,9 @@ func main() {
 						TireTempRL:    tempRL,
 						TireTempRR:    tempRR,
 						DistToLine:    distToLine,
+						Lateral:       pr.Lateral,
+						HeadingErr:    pr.HeadingErr,
+						Balance:       bal,
 					})
 					if idx > 0 && currentSurface != "" && currentSurface != lastSurface {
 						res.events = append(res.events, eventOut{
@@ -798,8 +1352,12 @@ func main() {
 					continue
 				}
 				lapNum, relS := track.FindLapAndRelS(sess.lapIdx, sess.track, ev.Index)
-				px, py := sess.track[ev.Index].X, sess.track[ev.Index].Y
+				px, py := mapTrack[ev.Index].X, mapTrack[ev.Index].Y
 				mi, mRelS, mx, my, dist := track.MapRelSToMaster(masterTrack, relS, px, py)
+				if pr, ok := projected[ev.Index]; ok {
+					relS = pr.RelS
+					mi, mRelS, mx, my, dist = pr.Index, pr.RelS, pr.X, pr.Y, pr.DistanceSq
+				}
 				rp := 0
 				if ev.Index >= 0 && ev.Index < len(sess.samples) {
 					rp = sess.samples[ev.Index].RacePosition
@@ -818,6 +1376,9 @@ func main() {
 					MasterY:    my,
 					DistanceSq: dist,
 					RacePos:    rp,
+					Duration:   ev.Duration,
+					Wheel:      ev.Wheel,
+					Severity:   ev.Severity,
 				}
 				res.events = append(res.events, eo)
 			}
@@ -876,20 +1437,86 @@ func main() {
 		}
 	}
 
-	// Sort events by time to make the viewer list ordered.
-	sort.Slice(out.Events, func(i, j int) bool {
-		return out.Events[i].Time < out.Events[j].Time
-	})
+	// An imported reference becomes a ghost car and the delta baseline.
+	var importedRef *track.TimeCurve
+	if refFile != nil {
+		ghost, curve, err := ghostFromReference(refFile, masterIndex, frameX, frameY)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: reference %s ignored: %v\n", *referencePath, err)
+		} else {
+			out.Cars = append(out.Cars, ghost)
+			importedRef = &curve
+		}
+	}
+
+	// Distance-aligned delta against the chosen reference lap.
+	curves := make([]map[int]track.TimeCurve, len(out.Cars))
+	for ci := range out.Cars {
+		curves[ci] = lapCurves(out.Cars[ci].Points)
+	}
+	// A stored definition's sector gates keep splits comparable across sessions;
+	// without one, laps keep ComputeLapMetrics' equal thirds.
+	if trackDef != nil {
+		for ci := range out.Cars {
+			laps := out.Cars[ci].LapTimes
+			for k := range laps {
+				if c, ok := curves[ci][laps[k].Lap]; ok {
+					laps[k].SectorTime = track.SectorTimes(c, sectorGates)
+				}
+			}
+			track.FillSectorDeltas(laps)
+		}
+	}
+	refs := resolveDeltaRefs(*deltaRefSpec, importedRef, out.Cars, curves)
+	if *referenceOut != "" {
+		if err := writeReferenceFile(*referenceOut, *referenceLap, out.Cars, curves, masterTrack, frameX, frameY); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing reference: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "wrote reference %s\n", *referenceOut)
+	}
+	for ci := range out.Cars {
+		ref := refs[ci]
+		out.Cars[ci].DeltaRef = ref.label
+		for k := range out.Cars[ci].Points {
+			p := &out.Cars[ci].Points[k]
+			p.Delta = track.DeltaAt(curves[ci][p.Lap], ref.curve, p.RelS)
+		}
+	}
 
 	// Corner stats per car
 	for ci := range out.Cars {
 		sum, perLap := analyzeCorners(out.Cars[ci].Points, masterTrack, cornerDefs)
+		applyCornerDeltas(sum, perLap, cornerDefs, curves[ci], refs[ci].curve)
+		applyCornerPhases(sum, perLap, out.Cars[ci].Points, cornerDefs)
 		out.Cars[ci].Corners = sum
 		out.Cars[ci].CornersLap = perLap
-		segSum, segLap := analyzeSegments(out.Cars[ci].Points, masterTrack, segmentDefs)
+		out.Events = append(out.Events, analyzeThrottle(&out.Cars[ci], cornerDefs, segmentDefs)...)
+		out.Events = append(out.Events, analyzeSteering(&out.Cars[ci], cornerDefs)...)
+		segSum, segLap := analyzeSegments(out.Cars[ci].Points, masterTrack, segmentDefs, segmentFilter)
 		out.Cars[ci].Segments = segSum
 		out.Cars[ci].SegmentsLap = segLap
 	}
+	countWheelSlip(out.Cars, out.Events, cornerDefs)
+	countHandbrake(out.Cars, out.Events, cornerDefs)
+	// Braking zones need every car's best corner passes, so they come after corner stats.
+	out.Events = append(out.Events, analyzeBrakeZones(out.Cars, cornerDefs)...)
+
+	// Speed traps: user-defined ones plus one at each straight's average top speed.
+	avgSpeed := make([]float64, len(masterTrack))
+	for i := range avgSpeed {
+		if countSpeed[i] > 0 {
+			avgSpeed[i] = sumSpeed[i] / float64(countSpeed[i])
+		}
+	}
+	traps := append(append([]track.SpeedTrap(nil), userTraps...), track.AutoSpeedTraps(masterTrack, avgSpeed, segmentDefs, userTraps)...)
+	track.NumberSpeedTraps(traps)
+	out.SpeedTraps = analyzeSpeedTraps(out.Cars, traps, masterTrack)
+
+	// Sort events by time to make the viewer list ordered.
+	sort.Slice(out.Events, func(i, j int) bool {
+		return out.Events[i].Time < out.Events[j].Time
+	})
 
 	if lappedCount == 0 && sprintCount > 0 {
 		out.RaceType = "sprint"
@@ -969,15 +1596,165 @@ func main() {
 }
 
 type sessionResult struct {
-	path    string
-	track   []models.Trackpoint
-	samples []models.Sample
-	events  []models.Event
-	race    string
-	lapIdx  []int
-	dist    float64
-	dur     float64
-	err     error
+	path      string
+	window    cropWindow
+	track     []models.Trackpoint
+	samples   []models.Sample
+	events    []models.Event
+	race      string
+	gateable  bool // lapped by position, so a stored start/finish gate can re-split it
+	lapIdx    []int
+	lapTypes  []string
+	overrides *track.LapOverrides
+	originX   float64 // world position subtracted by BuildTrack
+	originY   float64
+	dist      float64
+	dur       float64
+	err       error
+}
+
+// cropWindow limits a session to a time or lap range before the track is built.
+type cropWindow struct {
+	start track.SessionBound
+	end   track.SessionBound
+}
+
+func parseCropWindow(start, end string) (cropWindow, error) {
+	var w cropWindow
+	var err error
+	if w.start, err = track.ParseSessionBound(start); err != nil {
+		return w, err
+	}
+	if w.end, err = track.ParseSessionBound(end); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+type manifestEntry struct {
+	path   string
+	window cropWindow
+}
+
+// loadManifest reads a JSON manifest of the form
+// {"sessions": [{"file": "a.csv", "start": "lap:2", "end": "600"}]}.
+// Relative file paths are resolved against the manifest's directory.
+func loadManifest(path string) ([]manifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Sessions []struct {
+			File  string `json:"file"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var out []manifestEntry
+	for _, s := range m.Sessions {
+		if s.File == "" {
+			continue
+		}
+		file := s.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		w, err := parseCropWindow(s.Start, s.End)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.File, err)
+		}
+		out = append(out, manifestEntry{path: file, window: w})
+	}
+	return out, nil
+}
+
+// lapOverridesPath returns the sidecar override file for a CSV (session.csv -> session.laps.json).
+func lapOverridesPath(csvPath string) string {
+	return strings.TrimSuffix(csvPath, filepath.Ext(csvPath)) + ".laps.json"
+}
+
+// loadLapOverrides reads the sidecar override file for a CSV; a missing file yields nil.
+func loadLapOverrides(csvPath string) (*track.LapOverrides, error) {
+	path := lapOverridesPath(csvPath)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ov track.LapOverrides
+	if err := json.Unmarshal(data, &ov); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ov.Source = path
+	return &ov, nil
+}
+
+// gateLaps splits a session at a stored start/finish gate (world coordinates). ok is
+// false when the session does not pass the gate often enough to split it.
+func gateLaps(samples []models.Sample, tp []models.Trackpoint, def *track.TrackDef, minSpacing float64) ([]int, bool) {
+	ox, oy := track.TrackOrigin(samples)
+	gate := def.StartFinish
+	idx := track.DetectLapsNearPoint(tp, gate.X-ox, gate.Y-oy, gate.Radius, minSpacing)
+	return idx, len(idx) > 2
+}
+
+// finishLaps applies a session's lap overrides (nil for none) to the detected
+// boundaries and classifies the resulting laps.
+func finishLaps(samples []models.Sample, tp []models.Trackpoint, lapIdx []int, ov *track.LapOverrides) ([]int, []string, error) {
+	var excluded []bool
+	if ov != nil {
+		var err error
+		if lapIdx, excluded, err = track.ApplyLapOverrides(samples, tp, lapIdx, ov); err != nil {
+			return nil, nil, err
+		}
+	}
+	lapTypes := track.ClassifyLaps(samples, tp, lapIdx)
+	for i := range lapTypes {
+		if i < len(excluded) && excluded[i] {
+			lapTypes[i] = track.LapExcluded
+		}
+	}
+	return lapIdx, lapTypes, nil
+}
+
+// eventsInRange keeps events whose sample index lies within [start, end).
+func eventsInRange(events []models.Event, start, end int) []models.Event {
+	var out []models.Event
+	for _, ev := range events {
+		if ev.Index >= start && ev.Index < end {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// uniqueInputs drops files named more than once (by -file, -folder or the manifest),
+// keeping the first position. The manifest's spelling of a path wins so its crop
+// window still applies.
+func uniqueInputs(paths []string, windows map[string]cropWindow) []string {
+	var out []string
+	at := make(map[string]int)
+	for _, p := range paths {
+		key, err := filepath.Abs(p)
+		if err != nil {
+			key = filepath.Clean(p)
+		}
+		if i, ok := at[key]; ok {
+			if _, listed := windows[p]; listed {
+				out[i] = p
+			}
+			continue
+		}
+		at[key] = len(out)
+		out = append(out, p)
+	}
+	return out
 }
 
 func filesFromFolders(folders []string) []string {
@@ -1016,42 +1793,1017 @@ func serveViewer(addr, webDir, dataPath string) {
 	log.Fatal(http.ListenAndServe(addr, nil))
 }
 
-// bestSectorTimes returns the best sector times per index from lap metrics.
-func bestSectorTimes(laps []track.LapMetrics) []float64 {
-	var best []float64
-	for _, lm := range laps {
-		for i, t := range lm.SectorTime {
-			if t <= 0 {
+// lapCurves groups a car's mapped points by lap into master distance-time curves.
+func lapCurves(points []carPoint) map[int]track.TimeCurve {
+	relS := make(map[int][]float64)
+	times := make(map[int][]float64)
+	for _, p := range points {
+		relS[p.Lap] = append(relS[p.Lap], p.RelS)
+		times[p.Lap] = append(times[p.Lap], p.Time)
+	}
+	out := make(map[int]track.TimeCurve, len(relS))
+	for lap := range relS {
+		if c := track.BuildTimeCurve(relS[lap], times[lap]); c.Valid() {
+			out[lap] = c
+		}
+	}
+	return out
+}
+
+type deltaRef struct {
+	label string
+	curve track.TimeCurve
+}
+
+// bestLapCurve returns the fastest lap with a usable curve, preferring flying laps.
+func bestLapCurve(car carOut, curves map[int]track.TimeCurve) (int, float64, bool) {
+	for _, flyingOnly := range []bool{true, false} {
+		bestLap, bestTime := 0, math.Inf(1)
+		for _, lm := range car.LapTimes {
+			if flyingOnly && lm.Type != track.LapFlying {
+				continue
+			}
+			if lm.Type == track.LapExcluded || lm.LapTime <= 0 {
 				continue
 			}
-			if i >= len(best) {
-				best = append(best, t)
-			} else if best[i] == 0 || t < best[i] {
-				best[i] = t
+			if _, ok := curves[lm.Lap]; ok && lm.LapTime < bestTime {
+				bestLap, bestTime = lm.Lap, lm.LapTime
 			}
 		}
+		if bestLap > 0 {
+			return bestLap, bestTime, true
+		}
 	}
-	return best
+	return 0, 0, false
 }
 
-func expectedTimeForProgress(best []float64, lapLen, relS float64) float64 {
-	if lapLen <= 0 || len(best) == 0 {
-		return 0
-	}
-	sectorLen := lapLen / float64(len(best))
-	idx := int(relS / sectorLen)
-	if idx >= len(best) {
-		idx = len(best) - 1
-	}
-	inSector := relS - float64(idx)*sectorLen
-	expected := 0.0
-	for i := 0; i < idx; i++ {
-		expected += best[i]
+// pickLap finds a car and lap by spec: "best" (fastest lap of any car) or "<source>:<lap>".
+// An imported ghost is never picked.
+func pickLap(spec string, cars []carOut, curves []map[int]track.TimeCurve) (int, int, bool) {
+	if spec == "best" {
+		bestCar, bestLap, bestTime := -1, 0, math.Inf(1)
+		for ci := range cars {
+			if isGhost(cars[ci]) {
+				continue
+			}
+			if lap, t, ok := bestLapCurve(cars[ci], curves[ci]); ok && t < bestTime {
+				bestCar, bestLap, bestTime = ci, lap, t
+			}
+		}
+		return bestCar, bestLap, bestCar >= 0
+	}
+	cut := strings.LastIndex(spec, ":")
+	if cut <= 0 {
+		return 0, 0, false
+	}
+	lap, err := strconv.Atoi(spec[cut+1:])
+	if err != nil {
+		return 0, 0, false
+	}
+	for ci := range cars {
+		if cars[ci].Source == spec[:cut] && !isGhost(cars[ci]) {
+			if _, ok := curves[ci][lap]; ok {
+				return ci, lap, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// resolveDeltaRefs picks the reference curve for each car from spec: "own-best"
+// (each car's fastest lap), "best" (fastest lap of any car), "<source>:<lap>", or an
+// imported curve. Unknown references fall back to own-best with a warning.
+func resolveDeltaRefs(spec string, imported *track.TimeCurve, cars []carOut, curves []map[int]track.TimeCurve) []deltaRef {
+	refs := make([]deltaRef, len(cars))
+	ownBest := func() {
+		for ci := range cars {
+			if lap, _, ok := bestLapCurve(cars[ci], curves[ci]); ok {
+				refs[ci] = deltaRef{label: fmt.Sprintf("%s lap %d", cars[ci].Source, lap), curve: curves[ci][lap]}
+			}
+		}
+	}
+	shared := func(r deltaRef) {
+		for ci := range refs {
+			refs[ci] = r
+		}
 	}
-	if sectorLen > 0 {
-		expected += best[idx] * (inSector / sectorLen)
+
+	switch {
+	case imported != nil:
+		label := "reference"
+		for ci := range cars {
+			if isGhost(cars[ci]) {
+				label = cars[ci].Source
+			}
+		}
+		shared(deltaRef{label: label, curve: *imported})
+	case spec == "" || spec == "own-best":
+		ownBest()
+	default:
+		if ci, lap, ok := pickLap(spec, cars, curves); ok {
+			shared(deltaRef{label: fmt.Sprintf("%s lap %d", cars[ci].Source, lap), curve: curves[ci][lap]})
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: delta reference %q not found, using own-best\n", spec)
+			ownBest()
+		}
 	}
-	return expected
+	return refs
+}
+
+// isGhost reports whether car is a ghost built from an imported reference. Ghosts
+// are drawn and analysed but kept out of lap picking and field rankings.
+func isGhost(car carOut) bool {
+	return car.RaceType == "ghost"
+}
+
+// applyCornerDeltas fills time gained/lost per corner against the reference curve.
+func applyCornerDeltas(sum []cornerStatOut, perLap []cornerLapStatOut, defs []track.CornerDef, curves map[int]track.TimeCurve, ref track.TimeCurve) {
+	if !ref.Valid() {
+		return
+	}
+	totals := make(map[int]float64)
+	counts := make(map[int]int)
+	for k := range perLap {
+		ci := perLap[k].Corner
+		lap, ok := curves[perLap[k].Lap]
+		if !ok || ci < 0 || ci >= len(defs) {
+			continue
+		}
+		perLap[k].TimeDelta = track.SectionDelta(lap, ref, defs[ci].StartS, defs[ci].EndS)
+		totals[ci] += perLap[k].TimeDelta
+		counts[ci]++
+	}
+	for k := range sum {
+		if n := counts[sum[k].Corner]; n > 0 {
+			sum[k].TimeDelta = totals[sum[k].Corner] / float64(n)
+		}
+	}
+}
+
+// phaseSamplesByLap groups a car's points by lap, alongside the same points as
+// track.PhaseSample (speed in mph) for the corner and input analyses.
+func phaseSamplesByLap(points []carPoint) (map[int][]carPoint, map[int][]track.PhaseSample) {
+	pts := make(map[int][]carPoint)
+	samples := make(map[int][]track.PhaseSample)
+	for _, p := range points {
+		pts[p.Lap] = append(pts[p.Lap], p)
+		samples[p.Lap] = append(samples[p.Lap], track.PhaseSample{
+			RelS:     p.RelS,
+			Time:     p.Time,
+			Speed:    p.SpeedMPH,
+			Throttle: p.Throttle,
+			Brake:    p.Brake,
+			Steer:    p.SteerDeg,
+			YawRate:  p.YawRate,
+			Pedals:   p.Pedals,
+		})
+	}
+	return pts, samples
+}
+
+// applyCornerPhases splits every lap's pass through each corner into phases and
+// compares it with the lap that took the corner quickest.
+func applyCornerPhases(sum []cornerStatOut, perLap []cornerLapStatOut, points []carPoint, defs []track.CornerDef) {
+	_, byLap := phaseSamplesByLap(points)
+	bestLap := make(map[int]int)
+	bestPhases := make(map[int]track.CornerPhases)
+	for k := range perLap {
+		ci := perLap[k].Corner
+		if ci < 0 || ci >= len(defs) {
+			continue
+		}
+		ph, ok := track.AnalyzeCornerPhases(byLap[perLap[k].Lap], defs, ci)
+		if !ok {
+			continue
+		}
+		perLap[k].Phases = &ph
+		if best, seen := bestPhases[ci]; ph.CornerTime > 0 && (!seen || ph.CornerTime < best.CornerTime) {
+			bestPhases[ci] = ph
+			bestLap[ci] = perLap[k].Lap
+		}
+	}
+	for k := range perLap {
+		best, ok := bestPhases[perLap[k].Corner]
+		if !ok || perLap[k].Phases == nil {
+			continue
+		}
+		d := track.ComparePhases(*perLap[k].Phases, best, bestLap[perLap[k].Corner])
+		perLap[k].VsBest = &d
+	}
+	for k := range sum {
+		if best, ok := bestPhases[sum[k].Corner]; ok {
+			sum[k].BestLap = bestLap[sum[k].Corner]
+			sum[k].BestPhases = &best
+		}
+	}
+}
+
+// analyzeThrottle fills per-lap and per-corner throttle use for a car (corner phases
+// must already be applied) and returns coasting and lift events. Cars without pedal
+// channels are skipped; the per-car figures leave out out, in and partial laps.
+func analyzeThrottle(car *carOut, defs []track.CornerDef, segments []track.SegmentDef) []eventOut {
+	lapPts, byLap := phaseSamplesByLap(car.Points)
+	var all []track.PhaseSample
+	laps := make([]int, 0, len(byLap))
+	for lap, samples := range byLap {
+		laps = append(laps, lap)
+		all = append(all, samples...)
+	}
+	if !track.HasPedals(all) {
+		return nil
+	}
+	sort.Ints(laps)
+	counted := aggregateLaps(*car)
+
+	var events []eventOut
+	var total track.ThrottleStats
+	aggregated := 0
+	car.ThrottleLaps = nil
+	for _, lap := range laps {
+		samples := byLap[lap]
+		st := track.ThrottleUse(samples, math.Inf(-1), math.Inf(1))
+		st.Lap = lap
+		for _, iv := range track.DetectThrottleIntervals(samples, segments) {
+			a, b := lapPts[lap][iv.Start], lapPts[lap][iv.End]
+			note := fmt.Sprintf("coasting %.1fs over %.0fm", b.Time-a.Time, b.RelS-a.RelS)
+			if iv.Type == "lift" {
+				st.Lifts++
+				note = fmt.Sprintf("lift-off %.1fs on straight", b.Time-a.Time)
+			} else {
+				st.Coasts++
+			}
+			events = append(events, eventOut{
+				Type:       iv.Type,
+				Source:     car.Source,
+				Time:       a.Time,
+				Note:       note,
+				Lap:        lap,
+				RelS:       a.RelS,
+				MasterRelS: a.RelS,
+				MasterX:    a.MasterX,
+				MasterY:    a.MasterY,
+				Duration:   b.Time - a.Time,
+			})
+		}
+		car.ThrottleLaps = append(car.ThrottleLaps, st)
+		if !counted[lap] {
+			continue
+		}
+		aggregated++
+		total.Time += st.Time
+		total.FullTime += st.FullTime
+		total.PartialTime += st.PartialTime
+		total.CoastTime += st.CoastTime
+		total.BrakeTime += st.BrakeTime
+		total.FullDist += st.FullDist
+		total.CoastDist += st.CoastDist
+		total.Lifts += st.Lifts
+		total.Coasts += st.Coasts
+	}
+
+	delays := make(map[int][]float64)
+	var allDelays []float64
+	for k := range car.CornersLap {
+		cl := &car.CornersLap[k]
+		if cl.Corner < 0 || cl.Corner >= len(defs) {
+			continue
+		}
+		c := defs[cl.Corner]
+		st := track.ThrottleUse(byLap[cl.Lap], c.StartS, c.EndS)
+		if cl.Phases != nil && cl.Phases.ThrottleS > 0 {
+			st.PickupDelay = cl.Phases.MidTime
+		}
+		cl.Throttle = &st
+		if !counted[cl.Lap] {
+			continue
+		}
+		if cl.Phases != nil && cl.Phases.ThrottleS > 0 {
+			delays[cl.Corner] = append(delays[cl.Corner], st.PickupDelay)
+			allDelays = append(allDelays, st.PickupDelay)
+		}
+	}
+	for k := range car.Corners {
+		car.Corners[k].PickupDelay = mean(delays[car.Corners[k].Corner])
+	}
+
+	if n := float64(aggregated); n > 0 && total.Time > 0 {
+		car.Throttle = &throttleOut{
+			Laps:         aggregated,
+			FullPct:      100 * total.FullTime / total.Time,
+			PartialPct:   100 * total.PartialTime / total.Time,
+			CoastPct:     100 * total.CoastTime / total.Time,
+			BrakePct:     100 * total.BrakeTime / total.Time,
+			FullDist:     total.FullDist / n,
+			CoastDist:    total.CoastDist / n,
+			LiftsPerLap:  float64(total.Lifts) / n,
+			CoastsPerLap: float64(total.Coasts) / n,
+			PickupDelay:  mean(allDelays),
+		}
+	}
+	return events
+}
+
+// aggregateLaps reports which of a car's laps count toward its per-car and per-corner
+// figures: flying laps, or laps that were not classified.
+func aggregateLaps(car carOut) map[int]bool {
+	counted := make(map[int]bool, len(car.LapTimes))
+	for _, lm := range car.LapTimes {
+		counted[lm.Lap] = lm.Type == "" || lm.Type == track.LapFlying
+	}
+	return counted
+}
+
+// analyzeSteering fills per-lap and per-corner steering stats for a car and returns
+// countersteer and snap events. Cars without a steering channel are skipped; like
+// throttle, the per-car and per-corner figures leave out out, in and partial laps.
+func analyzeSteering(car *carOut, defs []track.CornerDef) []eventOut {
+	lapPts, byLap := phaseSamplesByLap(car.Points)
+	var all []track.PhaseSample
+	laps := make([]int, 0, len(byLap))
+	for lap, samples := range byLap {
+		laps = append(laps, lap)
+		all = append(all, samples...)
+	}
+	if !track.HasSteering(all) {
+		return nil
+	}
+	sort.Ints(laps)
+	sign := track.SteerYawSign(all)
+	counted := aggregateLaps(*car)
+
+	var events []eventOut
+	var sum track.SteeringStats
+	aggregated := 0
+	car.SteeringLaps = nil
+	for _, lap := range laps {
+		samples := byLap[lap]
+		st := track.SteeringUse(samples, math.Inf(-1), math.Inf(1), sign)
+		st.Lap = lap
+		car.SteeringLaps = append(car.SteeringLaps, st)
+		for _, iv := range track.DetectSteerIntervals(samples, sign) {
+			a, b := lapPts[lap][iv.Start], lapPts[lap][iv.End]
+			note := fmt.Sprintf("counter-steer %.1fs", b.Time-a.Time)
+			if iv.Type == "snap" {
+				note = fmt.Sprintf("snap caught with %.1f lock/s counter-steer", iv.PeakRate)
+			}
+			events = append(events, eventOut{
+				Type:       iv.Type,
+				Source:     car.Source,
+				Time:       a.Time,
+				Note:       note,
+				Lap:        lap,
+				RelS:       a.RelS,
+				MasterRelS: a.RelS,
+				MasterX:    a.MasterX,
+				MasterY:    a.MasterY,
+				Duration:   b.Time - a.Time,
+			})
+		}
+		if !counted[lap] {
+			continue
+		}
+		aggregated++
+		sum.Time += st.Time
+		sum.Reversals += st.Reversals
+		sum.Corrections += st.Corrections
+		sum.SawingTime += st.SawingTime
+		sum.CounterSteers += st.CounterSteers
+		sum.Smoothness += st.Smoothness
+		sum.RateRMS += st.RateRMS
+	}
+
+	smooth := make(map[int][]float64)
+	rates := make(map[int][]float64)
+	for k := range car.CornersLap {
+		cl := &car.CornersLap[k]
+		if cl.Corner < 0 || cl.Corner >= len(defs) {
+			continue
+		}
+		c := defs[cl.Corner]
+		st := track.SteeringUse(byLap[cl.Lap], c.StartS, c.EndS, sign)
+		if st.Time <= 0 {
+			continue
+		}
+		cl.Steering = &st
+		if !counted[cl.Lap] {
+			continue
+		}
+		smooth[cl.Corner] = append(smooth[cl.Corner], st.Smoothness)
+		rates[cl.Corner] = append(rates[cl.Corner], st.ReversalRate)
+	}
+	for k := range car.Corners {
+		car.Corners[k].Smoothness = mean(smooth[car.Corners[k].Corner])
+		car.Corners[k].ReversalRate = mean(rates[car.Corners[k].Corner])
+	}
+
+	if n := float64(aggregated); n > 0 && sum.Time > 0 {
+		car.Steering = &steeringOut{
+			Laps:                aggregated,
+			ReversalRate:        float64(sum.Reversals) / sum.Time * 60,
+			Smoothness:          sum.Smoothness / n,
+			RateRMS:             sum.RateRMS / n,
+			CorrectionsPerLap:   float64(sum.Corrections) / n,
+			SawingPerLap:        sum.SawingTime / n,
+			CounterSteersPerLap: float64(sum.CounterSteers) / n,
+		}
+	}
+	return events
+}
+
+// countWheelSlip tallies lock-up and wheelspin events per wheel for each car and
+// corner. A lock-up belongs to the corner being braked for (the next corner end at or
+// after it); wheelspin belongs to the corner being exited (the last corner started).
+func countWheelSlip(cars []carOut, events []eventOut, defs []track.CornerDef) {
+	bySource := make(map[string]int, len(cars))
+	for ci := range cars {
+		bySource[cars[ci].Source] = ci
+	}
+	bump := func(m *map[string]int, wheel string) {
+		if *m == nil {
+			*m = make(map[string]int)
+		}
+		(*m)[wheel]++
+	}
+	for _, ev := range events {
+		if ev.Type != "lockup" && ev.Type != "wheelspin" {
+			continue
+		}
+		ci, ok := bySource[ev.Source]
+		if !ok {
+			continue
+		}
+		car := &cars[ci]
+		corner := -1
+		for i, c := range defs {
+			if ev.Type == "lockup" && c.EndS >= ev.RelS {
+				corner = i
+				break
+			}
+			if ev.Type == "wheelspin" && c.StartS <= ev.RelS {
+				corner = i
+			}
+		}
+		stat := cornerStat(car, corner)
+		if ev.Type == "lockup" {
+			bump(&car.Lockups, ev.Wheel)
+			if stat != nil {
+				bump(&stat.Lockups, ev.Wheel)
+			}
+		} else {
+			bump(&car.Wheelspins, ev.Wheel)
+			if stat != nil {
+				bump(&stat.Wheelspins, ev.Wheel)
+			}
+		}
+	}
+}
+
+func gearsOut(gears []track.GearStats) []gearOut {
+	out := make([]gearOut, 0, len(gears))
+	for _, g := range gears {
+		out = append(out, gearOut{
+			Gear:         g.Gear,
+			Upshifts:     g.Upshifts,
+			Downshifts:   g.Downshifts,
+			ShiftRPM:     g.ShiftRPM,
+			OptimalRPM:   g.OptimalRPM,
+			DownshiftRPM: g.DownshiftRPM,
+			TorqueGap:    g.TorqueGap,
+			SpeedDropMPH: g.SpeedDrop * 2.23694,
+			SpeedDropKMH: g.SpeedDrop * 3.6,
+			EarlyShifts:  g.EarlyShifts,
+			LateShifts:   g.LateShifts,
+		})
+	}
+	return out
+}
+
+// engineCurve rebuilds the engine curve for a session and the per-gear RPM histogram
+// against its power band. The curve is nil without full-throttle power data.
+func engineCurve(samples []models.Sample) (*dynoOut, []gearRPMOut) {
+	const hpPerW, lbFtPerNm = 1 / 745.7, 0.737562
+	d := track.BuildDyno(samples)
+	var dyno *dynoOut
+	if len(d.Curve) > 0 {
+		dyno = &dynoOut{
+			PeakKW:        d.PeakPower / 1000,
+			PeakHP:        d.PeakPower * hpPerW,
+			PeakPowerRPM:  d.PeakPowerRPM,
+			PeakNm:        d.PeakTorque,
+			PeakLbFt:      d.PeakTorque * lbFtPerNm,
+			PeakTorqueRPM: d.PeakTorqueRPM,
+			BandLow:       d.BandLow,
+			BandHigh:      d.BandHigh,
+		}
+		for _, p := range d.Curve {
+			dyno.Curve = append(dyno.Curve, dynoPointOut{
+				RPM:     p.RPM,
+				KW:      p.Power / 1000,
+				HP:      p.Power * hpPerW,
+				Nm:      p.Torque,
+				LbFt:    p.Torque * lbFtPerNm,
+				Samples: p.Samples,
+			})
+		}
+	}
+	var hist []gearRPMOut
+	for _, h := range track.RPMHistogram(samples, d.BandLow, d.BandHigh) {
+		g := gearRPMOut{Gear: h.Gear, Time: h.Time, BandTime: h.BandTime}
+		if h.Time > 0 {
+			g.BandPct = 100 * h.BandTime / h.Time
+		}
+		for _, b := range h.Bins {
+			g.Bins = append(g.Bins, rpmBinOut{RPM: b.RPM, Time: b.Time})
+		}
+		hist = append(hist, g)
+	}
+	return dyno, hist
+}
+
+// gearboxOf estimates a session's gearing; nil when no gear could be fitted.
+func gearboxOf(samples []models.Sample) *gearboxOut {
+	gb := track.EstimateGearbox(samples)
+	if len(gb.Gears) == 0 {
+		return nil
+	}
+	out := &gearboxOut{
+		Redline:     gb.Redline,
+		TireRadiusM: gb.TireRadius,
+		MaxMPH:      gb.MaxSpeed * 2.23694,
+		MaxKMH:      gb.MaxSpeed * 3.6,
+	}
+	for k, g := range gb.Gears {
+		gr := gearRatioOut{
+			Gear:    g.Gear,
+			Ratio:   g.Ratio,
+			RPMDrop: g.RPMDrop,
+			TopMPH:  g.TopSpeed * 2.23694,
+			TopKMH:  g.TopSpeed * 3.6,
+			MaxRPM:  g.MaxRPM,
+		}
+		if k > 0 && gb.Gears[k-1].Gear == g.Gear-1 && gb.Gears[k-1].Ratio > 0 {
+			gr.Step = g.Ratio / gb.Gears[k-1].Ratio
+		}
+		if gb.Redline > 0 {
+			gr.ReachedPct = 100 * g.MaxRPM / gb.Redline
+		}
+		out.Gears = append(out.Gears, gr)
+	}
+	return out
+}
+
+// startOf converts a launch to output, with its time relative to the session start t0.
+func startOf(l track.Launch, t0 float64) *startOut {
+	return &startOut{
+		Time:          l.Time - t0,
+		Reaction:      l.Reaction,
+		ThrottleHeld:  l.Held,
+		MoveTime:      l.MoveTime,
+		LaunchRPM:     l.LaunchRPM,
+		Wheelspins:    l.Wheelspins,
+		WheelspinTime: l.WheelspinTime,
+		To100KMH:      l.To100,
+		To200KMH:      l.To200,
+		StartPos:      l.StartPos,
+		EndPos:        l.EndPos,
+		Gained:        l.Gained,
+		Window:        l.Window,
+	}
+}
+
+// analyzeSpeedTraps reads every car's laps at each trap, ranks the readings across
+// laps and cars (ghosts excluded), and returns the traps as master markers with the
+// fastest reading.
+func analyzeSpeedTraps(cars []carOut, traps []track.SpeedTrap, master []models.Trackpoint) []speedTrapOut {
+	type reading struct {
+		car, lap int
+		mph      float64
+	}
+	byTrap := make([][]reading, len(traps))
+	for ci := range cars {
+		cars[ci].SpeedTraps, cars[ci].SpeedTrapLaps = nil, nil
+		if isGhost(cars[ci]) {
+			continue
+		}
+		_, byLap := phaseSamplesByLap(cars[ci].Points)
+		laps := make([]int, 0, len(byLap))
+		for lap := range byLap {
+			laps = append(laps, lap)
+		}
+		sort.Ints(laps)
+		for ti, t := range traps {
+			for _, lap := range laps {
+				if mph, ok := track.TrapSpeed(byLap[lap], t); ok {
+					byTrap[ti] = append(byTrap[ti], reading{ci, lap, mph})
+				}
+			}
+		}
+	}
+
+	out := make([]speedTrapOut, 0, len(traps))
+	for ti, t := range traps {
+		m := speedTrapOut{ID: t.ID, Name: t.Name, Zone: t.Zone(), Auto: t.Auto, RelS: t.S}
+		_, _, m.X, m.Y, _ = track.MapRelSToMaster(master, t.S, 0, 0)
+		if t.Zone() {
+			m.EndS = t.EndS
+			_, _, m.EndX, m.EndY, _ = track.MapRelSToMaster(master, t.EndS, 0, 0)
+		}
+		rs := byTrap[ti]
+		sort.SliceStable(rs, func(a, b int) bool { return rs[a].mph > rs[b].mph })
+		if len(rs) > 0 {
+			m.BestMPH, m.BestKMH = rs[0].mph, rs[0].mph*1.60934
+			m.BestSource, m.BestLap = cars[rs[0].car].Source, rs[0].lap
+		}
+		// Per-car summaries, in order of each car's best reading.
+		stats := make(map[int]*trapStatOut)
+		var order []int
+		for rank, rd := range rs {
+			car := &cars[rd.car]
+			car.SpeedTrapLaps = append(car.SpeedTrapLaps, trapLapOut{ID: t.ID, Lap: rd.lap, MPH: rd.mph, KMH: rd.mph * 1.60934, Rank: rank + 1})
+			st := stats[rd.car]
+			if st == nil {
+				st = &trapStatOut{ID: t.ID, BestMPH: rd.mph, BestLap: rd.lap}
+				stats[rd.car] = st
+				order = append(order, rd.car)
+			}
+			st.Laps++
+			st.AvgMPH += rd.mph
+		}
+		for rank, ci := range order {
+			st := stats[ci]
+			st.AvgMPH /= float64(st.Laps)
+			st.BestKMH, st.AvgKMH = st.BestMPH*1.60934, st.AvgMPH*1.60934
+			st.Rank = rank + 1
+			cars[ci].SpeedTraps = append(cars[ci].SpeedTraps, *st)
+		}
+		out = append(out, m)
+	}
+	trapOrder := make(map[string]int, len(traps))
+	for ti, t := range traps {
+		trapOrder[t.ID] = ti
+	}
+	for ci := range cars {
+		laps := cars[ci].SpeedTrapLaps
+		sort.SliceStable(laps, func(a, b int) bool {
+			if laps[a].Lap != laps[b].Lap {
+				return laps[a].Lap < laps[b].Lap
+			}
+			return trapOrder[laps[a].ID] < trapOrder[laps[b].ID]
+		})
+	}
+	return out
+}
+
+// countHandbrake totals handbrake pulls and clutch kicks per car and per corner. Both
+// count toward the corner being entered or driven through.
+func countHandbrake(cars []carOut, events []eventOut, defs []track.CornerDef) {
+	bySource := make(map[string]int, len(cars))
+	for ci := range cars {
+		bySource[cars[ci].Source] = ci
+	}
+	for _, ev := range events {
+		if ev.Type != "handbrake" && ev.Type != "clutch_kick" {
+			continue
+		}
+		ci, ok := bySource[ev.Source]
+		if !ok {
+			continue
+		}
+		car := &cars[ci]
+		corner := -1
+		for i, c := range defs {
+			if c.EndS >= ev.RelS {
+				corner = i
+				break
+			}
+		}
+		stat := cornerStat(car, corner)
+		if ev.Type == "handbrake" {
+			car.HandbrakePulls++
+			car.HandbrakeTime += ev.Duration
+			if stat != nil {
+				stat.HandbrakePulls++
+				stat.HandbrakeTime += ev.Duration
+			}
+		} else {
+			car.ClutchKicks++
+			if stat != nil {
+				stat.ClutchKicks++
+			}
+		}
+	}
+}
+
+// cornerStat returns the car's stats for corner index i, or nil.
+func cornerStat(car *carOut, i int) *cornerStatOut {
+	if i < 0 {
+		return nil
+	}
+	for k := range car.Corners {
+		if car.Corners[k].Corner == i {
+			return &car.Corners[k]
+		}
+	}
+	return nil
+}
+
+// analyzeBrakeZones finds each lap's braking zone per corner and judges the brake
+// point against the car's own best pass (from applyCornerPhases) and the quickest
+// pass across all cars other than ghosts. Returns early_brake/late_brake events.
+func analyzeBrakeZones(cars []carOut, defs []track.CornerDef) []eventOut {
+	type pass struct {
+		car  int
+		lap  int
+		time float64
+	}
+	zones := make([]map[[2]int]track.BrakeZone, len(cars)) // [corner, lap]
+	lapPoints := make([]map[int][]carPoint, len(cars))
+	fieldBest := make(map[int]pass)
+	for ci := range cars {
+		zones[ci] = make(map[[2]int]track.BrakeZone)
+		var byLap map[int][]track.PhaseSample
+		lapPoints[ci], byLap = phaseSamplesByLap(cars[ci].Points)
+		for lap, samples := range byLap {
+			for i := range defs {
+				if z, ok := track.BrakeZoneFor(samples, defs, i); ok {
+					z.Lap = lap
+					zones[ci][[2]int{i, lap}] = z
+				}
+			}
+		}
+		for _, cs := range cars[ci].Corners {
+			if isGhost(cars[ci]) || cs.BestPhases == nil || cs.BestPhases.CornerTime <= 0 {
+				continue
+			}
+			if _, braked := zones[ci][[2]int{cs.Corner, cs.BestLap}]; !braked {
+				continue
+			}
+			if fb, ok := fieldBest[cs.Corner]; !ok || cs.BestPhases.CornerTime < fb.time {
+				fieldBest[cs.Corner] = pass{car: ci, lap: cs.BestLap, time: cs.BestPhases.CornerTime}
+			}
+		}
+	}
+
+	var events []eventOut
+	for ci := range cars {
+		ownBest := make(map[int]int)
+		for _, cs := range cars[ci].Corners {
+			ownBest[cs.Corner] = cs.BestLap
+		}
+		var outs []brakeZoneOut
+		for _, z := range zones[ci] {
+			zo := brakeZoneOut{
+				Corner:       z.Corner,
+				ID:           defs[z.Corner].ID,
+				Lap:          z.Lap,
+				PointS:       z.PointS,
+				ReleaseS:     z.ReleaseS,
+				DistanceM:    z.Distance,
+				Duration:     z.Duration,
+				PeakPressure: z.PeakPressure,
+				AvgPressure:  z.AvgPressure,
+				TrailTime:    z.TrailTime,
+				EntryMPH:     z.EntrySpeed,
+				MinMPH:       z.MinSpeed,
+				ScrubbedMPH:  z.Scrubbed,
+				EntryKMH:     z.EntrySpeed * 1.60934,
+				MinKMH:       z.MinSpeed * 1.60934,
+				ScrubbedKMH:  z.Scrubbed * 1.60934,
+			}
+			var notes []string
+			if own, ok := zones[ci][[2]int{z.Corner, ownBest[z.Corner]}]; ok && own.Lap != z.Lap {
+				zo.VsOwnBest = z.PointS - own.PointS
+				if zo.Judgement = track.JudgeBrakePoint(z.PointS, own.PointS); zo.Judgement != "" {
+					notes = append(notes, fmt.Sprintf("%+0.1fm vs own best (lap %d)", zo.VsOwnBest, own.Lap))
+				}
+			}
+			if fb, ok := fieldBest[z.Corner]; ok && fb.car != ci {
+				ref := zones[fb.car][[2]int{z.Corner, fb.lap}]
+				zo.VsField = z.PointS - ref.PointS
+				zo.FieldRef = fmt.Sprintf("%s:%d", cars[fb.car].Source, fb.lap)
+				judged := track.JudgeBrakePoint(z.PointS, ref.PointS)
+				if zo.Judgement == "" {
+					zo.Judgement = judged
+				}
+				if judged != "" {
+					notes = append(notes, fmt.Sprintf("%+0.1fm vs %s", zo.VsField, zo.FieldRef))
+				}
+			}
+			outs = append(outs, zo)
+			if zo.Judgement == "" {
+				continue
+			}
+			p := lapPoints[ci][z.Lap][z.Point]
+			events = append(events, eventOut{
+				Type:       zo.Judgement,
+				Source:     cars[ci].Source,
+				Time:       p.Time,
+				Note:       fmt.Sprintf("%s brake point %s", cornerLabel(defs[z.Corner]), strings.Join(notes, ", ")),
+				Lap:        z.Lap,
+				RelS:       p.RelS,
+				MasterRelS: p.RelS,
+				MasterX:    p.MasterX,
+				MasterY:    p.MasterY,
+			})
+		}
+		sort.Slice(outs, func(a, b int) bool {
+			if outs[a].Lap != outs[b].Lap {
+				return outs[a].Lap < outs[b].Lap
+			}
+			return outs[a].Corner < outs[b].Corner
+		})
+		cars[ci].BrakeZones = outs
+	}
+	return events
+}
+
+// cornerLabel names a corner for event notes: its user-supplied name, else its ID.
+func cornerLabel(c track.CornerDef) string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.ID != "":
+		return c.ID
+	}
+	return fmt.Sprintf("corner %d", c.Index+1)
+}
+
+func loadTrackDef(path string) (*track.TrackDef, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var def track.TrackDef
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(def.Master) < 2 {
+		return nil, fmt.Errorf("%s: track definition has no master points", path)
+	}
+	return &def, nil
+}
+
+// loadTrackLibrary reads every track definition (*.json) in dir, filling in
+// fingerprints for definitions saved without one.
+func loadTrackLibrary(dir string) ([]track.TrackDef, []string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+	var defs []track.TrackDef
+	var files []string
+	for _, p := range paths {
+		def, err := loadTrackDef(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping %s: %v\n", p, err)
+			continue
+		}
+		if def.Fingerprint == nil {
+			fp := track.FingerprintOf(def.MasterPoints(0, 0), def.Corners, def.Sprint)
+			def.Fingerprint = &fp
+		}
+		if def.Name == "" {
+			def.Name = strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		}
+		defs = append(defs, *def)
+		files = append(files, p)
+	}
+	return defs, files, nil
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// referenceFile is a portable reference lap: the master geometry and the lap's
+// channel trace keyed to master relS. Coordinates are world positions so the lap
+// can be re-matched onto a master built from other sessions of the same track.
+type referenceFile struct {
+	Label   string          `json:"label"`
+	Source  string          `json:"source"`
+	Lap     int             `json:"lap"`
+	LapTime float64         `json:"lapTime"`
+	Master  []masterOut     `json:"master"`
+	Curve   track.TimeCurve `json:"curve"`
+	Points  []refPoint      `json:"points"`
+}
+
+// refPoint is a reference lap sample with the world position actually driven.
+type refPoint struct {
+	carPoint
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Pedals bool    `json:"pedals,omitempty"`
+}
+
+// writeReferenceFile saves the lap chosen by spec (see pickLap) as a reference file.
+func writeReferenceFile(path, spec string, cars []carOut, curves []map[int]track.TimeCurve, master []models.Trackpoint, frameX, frameY float64) error {
+	ci, lap, ok := pickLap(spec, cars, curves)
+	if !ok {
+		return fmt.Errorf("lap %q not found", spec)
+	}
+	car := cars[ci]
+	ref := referenceFile{
+		Label:  fmt.Sprintf("%s lap %d", car.Source, lap),
+		Source: car.Source,
+		Lap:    lap,
+		Curve:  curves[ci][lap],
+	}
+	for _, lm := range car.LapTimes {
+		if lm.Lap == lap {
+			ref.LapTime = lm.LapTime
+		}
+	}
+	for _, p := range master {
+		ref.Master = append(ref.Master, masterOut{RelS: p.S, X: p.X + frameX, Y: p.Y + frameY})
+	}
+	t0 := math.NaN()
+	for _, p := range car.Points {
+		if p.Lap != lap {
+			continue
+		}
+		if math.IsNaN(t0) {
+			t0 = p.Time
+		}
+		p.Time -= t0
+		p.Lap = 1
+		p.Delta = 0
+		p.MasterX += frameX
+		p.MasterY += frameY
+		ref.Points = append(ref.Points, refPoint{carPoint: p, X: p.X + frameX, Y: p.Y + frameY, Pedals: p.Pedals})
+	}
+	return writeJSONFile(path, ref)
+}
+
+func loadReferenceFile(path string) (*referenceFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ref referenceFile
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(ref.Points) < 2 {
+		return nil, fmt.Errorf("%s: reference has no lap trace", path)
+	}
+	if ref.Label == "" {
+		ref.Label = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	// Older files carry only the projected line; drive that when x/y are missing.
+	for i := range ref.Points {
+		if p := &ref.Points[i]; p.X == 0 && p.Y == 0 {
+			p.X, p.Y = p.MasterX, p.MasterY
+		}
+	}
+	return &ref, nil
+}
+
+// ghostFromReference re-keys a reference lap onto the current master by projecting
+// its positions, returning it as a ghost car and its distance-time curve. References
+// recorded on a different track (RMS offset from the master > 25 m) are rejected.
+func ghostFromReference(ref *referenceFile, idx *track.MasterIndex, frameX, frameY float64) (carOut, track.TimeCurve, error) {
+	const maxRMS = 25.0
+	var refMaster []models.Trackpoint
+	for _, p := range ref.Master {
+		refMaster = append(refMaster, models.Trackpoint{S: p.RelS, X: p.X - frameX, Y: p.Y - frameY})
+	}
+	if rms := idx.MatchRMS(refMaster, 10); rms > maxRMS {
+		return carOut{}, track.TimeCurve{}, fmt.Errorf("track does not match (%.1fm RMS)", rms)
+	}
+
+	lap := make([]models.Trackpoint, len(ref.Points))
+	for i, p := range ref.Points {
+		lap[i] = models.Trackpoint{X: p.X - frameX, Y: p.Y - frameY, Theta: p.Heading}
+	}
+	ghost := carOut{
+		Source:   "ghost: " + ref.Label,
+		RaceType: "ghost",
+		LapTimes: []track.LapMetrics{{Lap: 1, LapTime: ref.LapTime, Type: track.LapFlying}},
+	}
+	var relS, times []float64
+	track.ProjectToMaster(lap, idx, 0, func(i int, pr track.Projection) {
+		p := ref.Points[i].carPoint
+		p.X, p.Y = lap[i].X, lap[i].Y
+		p.Pedals = ref.Points[i].Pedals
+		p.Lap = 1
+		p.RelS = pr.RelS
+		p.MasterX, p.MasterY = pr.X, pr.Y
+		p.Lateral, p.HeadingErr = pr.Lateral, pr.HeadingErr
+		ghost.Points = append(ghost.Points, p)
+		relS = append(relS, p.RelS)
+		times = append(times, p.Time)
+	})
+	curve := track.BuildTimeCurve(relS, times)
+	if !curve.Valid() {
+		return carOut{}, track.TimeCurve{}, errors.New("reference lap could not be matched onto the master")
+	}
+	return ghost, curve, nil
 }
 
 // pointAtTime returns interpolated carPoint at time t.
@@ -1121,6 +2873,17 @@ func percentile(vals []float64, p float64) float64 {
 	return s[lo]*(1-alpha) + s[hi]*alpha
 }
 
+func mean(vals []float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	return sum / float64(len(vals))
+}
+
 func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.CornerDef) ([]cornerStatOut, []cornerLapStatOut) {
 	if len(points) == 0 || len(defs) == 0 {
 		return nil, nil
@@ -1134,11 +2897,15 @@ func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.
 	out := make([]cornerStatOut, len(defs))
 	var perLap []cornerLapStatOut
 	for i, c := range defs {
-		stats := cornerStatOut{Corner: c.Index}
+		stats := cornerStatOut{Corner: c.Index, ID: c.ID}
+		var balSum float64
+		balLaps := 0
 		for _, lapPts := range byLap {
 			var entry, exit, min float64
 			min = math.MaxFloat64
 			found := false
+			var bal float64
+			balN := 0
 			for idx, p := range lapPts {
 				if p.RelS < c.StartS {
 					continue
@@ -1155,6 +2922,10 @@ func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.
 					min = p.SpeedMPH
 				}
 				exit = p.SpeedMPH
+				if p.Balance != 0 {
+					bal += p.Balance
+					balN++
+				}
 				// if near end, try to include next point if also within small range
 				_ = idx
 			}
@@ -1163,8 +2934,16 @@ func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.
 				stats.EntryMPH += entry
 				stats.MinMPH += min
 				stats.ExitMPH += exit
+				handling := ""
+				if balN > 0 {
+					bal /= float64(balN)
+					handling = track.BalanceClass(bal)
+					balSum += bal
+					balLaps++
+				}
 				perLap = append(perLap, cornerLapStatOut{
 					Corner:   c.Index,
+					ID:       c.ID,
 					Lap:      lapPts[0].Lap,
 					EntryMPH: entry,
 					MinMPH:   min,
@@ -1172,9 +2951,15 @@ func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.
 					EntryKMH: entry * 1.60934,
 					MinKMH:   min * 1.60934,
 					ExitKMH:  exit * 1.60934,
+					Balance:  bal,
+					Handling: handling,
 				})
 			}
 		}
+		if balLaps > 0 {
+			stats.Balance = balSum / float64(balLaps)
+			stats.Handling = track.BalanceClass(stats.Balance)
+		}
 		if stats.Count > 0 {
 			n := float64(stats.Count)
 			stats.EntryMPH /= n
@@ -1196,30 +2981,9 @@ func analyzeCorners(points []carPoint, master []models.Trackpoint, defs []track.
 	return compact, perLap
 }
 
-type segmentDef struct {
-	segType string
-	startS  float64
-	endS    float64
-}
-
-func buildSegments(master []models.Trackpoint, corners []track.CornerDef) []segmentDef {
-	var segments []segmentDef
-	lastEnd := 0.0
-	masterLen := master[len(master)-1].S
-	for _, c := range corners {
-		if c.StartS > lastEnd {
-			segments = append(segments, segmentDef{segType: "straight", startS: lastEnd, endS: c.StartS})
-		}
-		segments = append(segments, segmentDef{segType: "corner", startS: c.StartS, endS: c.EndS})
-		lastEnd = c.EndS
-	}
-	if lastEnd < masterLen {
-		segments = append(segments, segmentDef{segType: "straight", startS: lastEnd, endS: masterLen})
-	}
-	return segments
-}
-
-func analyzeSegments(points []carPoint, master []models.Trackpoint, defs []segmentDef) ([]segmentStatOut, []segmentLapStatOut) {
+// analyzeSegments summarises speed and time per segment. When types is non-empty
+// only segments whose type or corner type is listed are included.
+func analyzeSegments(points []carPoint, master []models.Trackpoint, defs []track.SegmentDef, types map[string]bool) ([]segmentStatOut, []segmentLapStatOut) {
 	if len(points) == 0 || len(defs) == 0 {
 		return nil, nil
 	}
@@ -1230,7 +2994,10 @@ func analyzeSegments(points []carPoint, master []models.Trackpoint, defs []segme
 	out := make([]segmentStatOut, len(defs))
 	var perLap []segmentLapStatOut
 	for i, s := range defs {
-		stat := segmentStatOut{Segment: i, Type: s.segType}
+		if len(types) > 0 && !types[s.Type] && !types[s.CornerType] {
+			continue
+		}
+		stat := segmentStatOut{Segment: i, Type: s.Type, CornerType: s.CornerType}
 		for _, lapPts := range byLap {
 			var entry, exit, min, max, avg float64
 			min = math.MaxFloat64
@@ -1239,10 +3006,10 @@ func analyzeSegments(points []carPoint, master []models.Trackpoint, defs []segme
 			var tStart, tEnd float64
 			found := false
 			for _, p := range lapPts {
-				if p.RelS < s.startS {
+				if p.RelS < s.StartS {
 					continue
 				}
-				if p.RelS > s.endS {
+				if p.RelS > s.EndS {
 					break
 				}
 				if !found {
@@ -1270,18 +3037,19 @@ func analyzeSegments(points []carPoint, master []models.Trackpoint, defs []segme
 				stat.AvgMPH += avg
 				stat.Time += (tEnd - tStart)
 				perLap = append(perLap, segmentLapStatOut{
-					Segment:  i,
-					Lap:      lapPts[0].Lap,
-					Type:     s.segType,
-					EntryMPH: entry,
-					MinMPH:   min,
-					ExitMPH:  exit,
-					AvgMPH:   avg,
-					Time:     tEnd - tStart,
-					EntryKMH: entry * 1.60934,
-					MinKMH:   min * 1.60934,
-					ExitKMH:  exit * 1.60934,
-					AvgKMH:   avg * 1.60934,
+					Segment:    i,
+					Lap:        lapPts[0].Lap,
+					Type:       s.Type,
+					CornerType: s.CornerType,
+					EntryMPH:   entry,
+					MinMPH:     min,
+					ExitMPH:    exit,
+					AvgMPH:     avg,
+					Time:       tEnd - tStart,
+					EntryKMH:   entry * 1.60934,
+					MinKMH:     min * 1.60934,
+					ExitKMH:    exit * 1.60934,
+					AvgKMH:     avg * 1.60934,
 				})
 			}
 		}
@@ -1343,6 +3111,7 @@ func LoadSamplesFromCSV(path string) ([]models.Sample, error) {
 	}
 
 	samples := make([]models.Sample, 0, len(rows))
+	raceOff := false // a race-off row was dropped since the last kept sample
 
 	parseOrZero := func(s string) float64 {
 		v, err := strconv.ParseFloat(s, 64)
@@ -1521,8 +3290,10 @@ func LoadSamplesFromCSV(path string) ([]models.Sample, error) {
 			s.IsRaceOn = isRaceOn
 		}
 		if s.IsRaceOn == 0 {
+			raceOff = true
 			continue
 		}
+		s.RaceStart, raceOff = raceOff, false
 
 		samples = append(samples, s)
 	}
@@ -1543,3 +3314,171 @@ func (m *multiFlag) Set(v string) error {
 	*m = append(*m, v)
 	return nil
 }
+
+// perfRunOut is one standing-start acceleration run. Times are seconds from the car
+// starting to roll; zero when the mark was not reached. Rank orders the runs of the
+// same car (ordinal and PI).
+type perfRunOut struct {
+	Source      string  `json:"source"`
+	Time        float64 `json:"time"` // from the session start
+	CarOrdinal  int     `json:"carOrdinal,omitempty"`
+	PI          int     `json:"pi,omitempty"`
+	Rank        int     `json:"rank"`
+	To60MPH     float64 `json:"to60MPH,omitempty"`
+	To100KMH    float64 `json:"to100KMH,omitempty"`
+	To200KMH    float64 `json:"to200KMH,omitempty"`
+	MPH60to130  float64 `json:"mph60to130,omitempty"`
+	QuarterMile float64 `json:"quarterMile,omitempty"`
+	QuarterMPH  float64 `json:"quarterTrapMPH,omitempty"`
+	QuarterKMH  float64 `json:"quarterTrapKMH,omitempty"`
+	HalfMile    float64 `json:"halfMile,omitempty"`
+	HalfMPH     float64 `json:"halfTrapMPH,omitempty"`
+	HalfKMH     float64 `json:"halfTrapKMH,omitempty"`
+	TopMPH      float64 `json:"topMPH"`
+	TopKMH      float64 `json:"topKMH"`
+	Brake100M   float64 `json:"brake100to0M,omitempty"` // meters
+}
+
+// perfCarOut is a car's best figures, ranked against the other cars. Best carries the
+// source and time of the car's top-ranked run and the best value of each metric over
+// all its runs.
+type perfCarOut struct {
+	CarOrdinal int        `json:"carOrdinal"`
+	PI         int        `json:"pi"`
+	Rank       int        `json:"rank"`
+	Runs       int        `json:"runs"`
+	Best       perfRunOut `json:"best"`
+}
+
+// runPerf finds acceleration runs in every session and writes them with a per-car
+// ranking to outPath, or stdout when empty.
+func runPerf(files []string, windows map[string]cropWindow, def cropWindow, outPath string) error {
+	var runs []perfRunOut
+	for _, path := range files {
+		samples, err := LoadSamplesFromCSV(path)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", path, err)
+		}
+		window, ok := windows[path]
+		if !ok {
+			window = def
+		}
+		if samples, err = track.CropSamples(samples, window.start, window.end); err != nil {
+			return fmt.Errorf("crop %s: %w", path, err)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		base := filepath.Base(path)
+		source := strings.TrimSuffix(base, filepath.Ext(base))
+		found := track.FindPerfRuns(samples)
+		fmt.Fprintf(os.Stderr, "%s: %d acceleration runs\n", source, len(found))
+		for _, r := range found {
+			runs = append(runs, perfRunOut{
+				Source:      source,
+				Time:        r.Time - samples[0].Time,
+				CarOrdinal:  r.CarOrdinal,
+				PI:          r.PI,
+				To60MPH:     r.To60MPH,
+				To100KMH:    r.To100KMH,
+				To200KMH:    r.To200KMH,
+				MPH60to130:  r.MPH60to130,
+				QuarterMile: r.Quarter,
+				QuarterMPH:  r.QuarterMPS * 2.23694,
+				QuarterKMH:  r.QuarterMPS * 3.6,
+				HalfMile:    r.Half,
+				HalfMPH:     r.HalfMPS * 2.23694,
+				HalfKMH:     r.HalfMPS * 3.6,
+				TopMPH:      r.TopSpeed * 2.23694,
+				TopKMH:      r.TopSpeed * 3.6,
+				Brake100M:   r.Brake100,
+			})
+		}
+	}
+
+	out := struct {
+		Mode string       `json:"mode"`
+		Runs []perfRunOut `json:"runs"`
+		Cars []perfCarOut `json:"cars"`
+	}{Mode: "perf", Runs: runs}
+
+	type carKey struct{ ordinal, pi int }
+	byCar := make(map[carKey][]int)
+	var keys []carKey
+	for i, r := range runs {
+		k := carKey{r.CarOrdinal, r.PI}
+		if byCar[k] == nil {
+			keys = append(keys, k)
+		}
+		byCar[k] = append(byCar[k], i)
+	}
+	for _, k := range keys {
+		idx := byCar[k]
+		sort.SliceStable(idx, func(a, b int) bool { return perfBetter(runs[idx[a]], runs[idx[b]]) })
+		car := perfCarOut{CarOrdinal: k.ordinal, PI: k.pi, Runs: len(idx)}
+		for rank, i := range idx {
+			runs[i].Rank = rank + 1
+			if rank == 0 {
+				car.Best = runs[i]
+			} else {
+				car.Best = bestPerf(car.Best, runs[i])
+			}
+		}
+		out.Cars = append(out.Cars, car)
+	}
+	sort.SliceStable(out.Cars, func(a, b int) bool { return perfBetter(out.Cars[a].Best, out.Cars[b].Best) })
+	for i := range out.Cars {
+		out.Cars[i].Rank = i + 1
+	}
+
+	if outPath == "" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	if err := writeJSONFile(outPath, out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", outPath)
+	return nil
+}
+
+// perfBetter orders runs by quarter-mile time, then 0-100 km/h, then top speed. Runs
+// that reached a mark beat runs that did not.
+func perfBetter(a, b perfRunOut) bool {
+	for _, m := range [][2]float64{{a.QuarterMile, b.QuarterMile}, {a.To100KMH, b.To100KMH}} {
+		switch {
+		case m[0] > 0 && m[1] > 0 && m[0] != m[1]:
+			return m[0] < m[1]
+		case m[0] > 0 && m[1] == 0:
+			return true
+		case m[0] == 0 && m[1] > 0:
+			return false
+		}
+	}
+	return a.TopMPH > b.TopMPH
+}
+
+// bestPerf merges run into best, keeping the best value of each metric.
+func bestPerf(best, run perfRunOut) perfRunOut {
+	faster := func(cur *float64, v float64) {
+		if v > 0 && (*cur == 0 || v < *cur) {
+			*cur = v
+		}
+	}
+	faster(&best.To60MPH, run.To60MPH)
+	faster(&best.To100KMH, run.To100KMH)
+	faster(&best.To200KMH, run.To200KMH)
+	faster(&best.MPH60to130, run.MPH60to130)
+	faster(&best.Brake100M, run.Brake100M)
+	if run.QuarterMile > 0 && (best.QuarterMile == 0 || run.QuarterMile < best.QuarterMile) {
+		best.QuarterMile, best.QuarterMPH, best.QuarterKMH = run.QuarterMile, run.QuarterMPH, run.QuarterKMH
+	}
+	if run.HalfMile > 0 && (best.HalfMile == 0 || run.HalfMile < best.HalfMile) {
+		best.HalfMile, best.HalfMPH, best.HalfKMH = run.HalfMile, run.HalfMPH, run.HalfKMH
+	}
+	if run.TopMPH > best.TopMPH {
+		best.TopMPH, best.TopKMH = run.TopMPH, run.TopKMH
+	}
+	return best
+}
@@ -103,6 +103,10 @@ type Sample struct {
 	HasInputAccel bool
 	HasInputBrake bool
 	HasInputSteer bool
+
+	// RaceStart marks the first race-on sample after IsRaceOn was 0. That is the start
+	// signal, or a resume from pause or a menu when the car is already moving.
+	RaceStart bool
 }
 
 type Trackpoint struct {
@@ -110,6 +114,8 @@ type Trackpoint struct {
 	X     float64
 	Y     float64
 	Theta float64
+	// Spread is the std dev (m) of the laps around a master point; zero elsewhere.
+	Spread float64
 }
 
 type Event struct {
@@ -123,4 +129,9 @@ type Event struct {
 	MasterY    float64
 	MasterRelS float64
 	DistanceSq float64
+	// Duration is the length (s) of interval events; zero for instantaneous ones.
+	Duration float64
+	// Wheel (FL, FR, RL, RR) and Severity for per-wheel events.
+	Wheel    string
+	Severity string
 }
@@ -0,0 +1,149 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+	"sort"
+)
+
+// Handling balance thresholds. Balance is positive for understeer, negative for
+// oversteer, and lies in [-1, 1].
+const (
+	balanceMinSpeed   = 10.0 // m/s; below this the bicycle model says little
+	balanceMinYaw     = 0.05 // rad/s of expected or measured yaw before the yaw term counts
+	balanceMinSlip    = 0.02 // rad of summed front+rear slip before the slip term counts
+	balanceLinearLat  = 4.0  // m/s^2; samples below this fit the steering gain
+	balanceEventLevel = 0.35 // |balance| that starts an understeer/oversteer interval
+	balanceEventMin   = 0.3  // seconds an interval must last
+	BalanceNeutral    = 0.15 // |average| below this classifies a corner as neutral
+)
+
+// FitSteerGain fits the car's steady-state bicycle-model gain k in
+// yaw = k * speed * steer (steer normalised to -1..1) from the linear range: samples
+// at speed with steering input and low lateral load, where the car mostly follows its
+// steering. The median ratio keeps the fit from being pulled by the understeer or
+// oversteer moments it is meant to expose. The gain absorbs wheelbase, steering
+// ratio and sign conventions; ok is false when there is no steering input to fit.
+func FitSteerGain(samples []models.Sample) (float64, bool) {
+	var ratios []float64
+	for _, s := range samples {
+		if !s.HasInputSteer {
+			continue
+		}
+		v := speedMPS(s)
+		delta := float64(s.Steer) / 127
+		if v < balanceMinSpeed || math.Abs(delta) < 0.02 || math.Abs(s.AngVelY) < balanceMinYaw/2 || math.Abs(s.AngVelY*v) > balanceLinearLat {
+			continue
+		}
+		ratios = append(ratios, s.AngVelY/(v*delta))
+	}
+	if len(ratios) < 20 {
+		return 0, false
+	}
+	sort.Float64s(ratios)
+	return ratios[len(ratios)/2], true
+}
+
+// HandlingBalance returns a per-sample balance value (positive = understeer, negative
+// = oversteer, 0 where unknown). It blends two signals: the measured yaw rate (AngVelY)
+// against the yaw rate the steering implies through the fitted bicycle model, and the
+// front versus rear tyre slip angles.
+func HandlingBalance(samples []models.Sample) []float64 {
+	out := make([]float64, len(samples))
+	gain, haveGain := FitSteerGain(samples)
+	for i, s := range samples {
+		v := speedMPS(s)
+		if v < balanceMinSpeed {
+			continue
+		}
+		var sum float64
+		terms := 0
+		if haveGain && s.HasInputSteer {
+			expected := gain * v * float64(s.Steer) / 127
+			measured := s.AngVelY
+			if scale := math.Max(math.Abs(expected), math.Abs(measured)); scale >= balanceMinYaw {
+				// Yawing beyond what the steering asks for is oversteer; yawing less is
+				// understeer. Yaw against the steering (counter-steer) or with the wheel
+				// straight is all oversteer.
+				excess := (measured - expected) * math.Copysign(1, expected) / scale
+				if measured*expected < 0 || expected == 0 {
+					excess = math.Abs(measured-expected) / scale
+				}
+				sum += clamp(-excess, -1, 1)
+				terms++
+			}
+		}
+		front := (math.Abs(s.TireSlipAngleFL) + math.Abs(s.TireSlipAngleFR)) / 2
+		rear := (math.Abs(s.TireSlipAngleRL) + math.Abs(s.TireSlipAngleRR)) / 2
+		if front+rear >= balanceMinSlip {
+			sum += (front - rear) / (front + rear)
+			terms++
+		}
+		if terms > 0 {
+			out[i] = sum / float64(terms)
+		}
+	}
+	return out
+}
+
+// BalanceClass names an average balance: "understeer", "oversteer" or "neutral".
+func BalanceClass(avg float64) string {
+	switch {
+	case avg >= BalanceNeutral:
+		return "understeer"
+	case avg <= -BalanceNeutral:
+		return "oversteer"
+	}
+	return "neutral"
+}
+
+// DetectBalanceEvents emits "understeer" and "oversteer" interval events where the
+// balance (from HandlingBalance) stays beyond balanceEventLevel. Events start at the
+// interval's first sample and carry its duration and peak value.
+func DetectBalanceEvents(samples []models.Sample, balance []float64) []models.Event {
+	var events []models.Event
+	start, kind := -1, ""
+	peak := 0.0
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		dur := samples[end].Time - samples[start].Time
+		if dur >= balanceEventMin {
+			events = append(events, models.Event{
+				Index:    start,
+				Time:     samples[start].Time,
+				Type:     kind,
+				Note:     fmt.Sprintf("%s %.1fs (peak %.2f)", kind, dur, peak),
+				Duration: dur,
+			})
+		}
+		start, kind, peak = -1, "", 0
+	}
+	for i := range samples {
+		if i >= len(balance) {
+			break
+		}
+		cur := ""
+		switch b := balance[i]; {
+		case b >= balanceEventLevel:
+			cur = "understeer"
+		case b <= -balanceEventLevel:
+			cur = "oversteer"
+		}
+		if cur != kind && start >= 0 {
+			flush(i - 1)
+		}
+		if cur != "" && start < 0 {
+			start, kind = i, cur
+		}
+		if cur != "" && math.Abs(balance[i]) > math.Abs(peak) {
+			peak = balance[i]
+		}
+	}
+	if start >= 0 {
+		flush(min(len(samples), len(balance)) - 1)
+	}
+	return events
+}
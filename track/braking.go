@@ -1,117 +1,75 @@
 package track
 
-import (
-	"fmt"
-	"forza/models"
-	"math"
-)
+// BrakeTolerance is how far (m) a brake point may sit from the reference brake point
+// for the same corner before it is called early or late.
+const BrakeTolerance = 12.0
 
-// DetectBrakeTiming flags early/late braking relative to the average brake-onset positions across laps.
-// Uses brake pedal input when available; falls back to longitudinal decel.
-func DetectBrakeTiming(samples []models.Sample, pts []models.Trackpoint, lapIdx []int, master []models.Trackpoint) []models.Event {
-	if len(samples) == 0 || len(pts) == 0 || len(lapIdx) < 2 || len(master) == 0 {
-		return nil
-	}
-	type onset struct {
-		idx  int
-		relS float64
-	}
-	brakeLow := 0.05  // normalized (0-1)
-	brakeHigh := 0.15 // normalized (0-1)
-	accelThresh := -3.0
-	tolerance := 12.0 // meters difference from average to call early/late
+// BrakeZone is one lap's braking into a corner. Positions are master relS; speeds are
+// in the units of PhaseSample.Speed.
+type BrakeZone struct {
+	Corner       int     // index into the corner list
+	Lap          int     // filled by the caller
+	Point        int     // lap sample index of the brake point
+	PointS       float64 // brake point
+	ReleaseS     float64
+	Distance     float64 // meters from brake point to release
+	Duration     float64 // seconds from brake point to release
+	PeakPressure float64 // 0..1
+	AvgPressure  float64 // 0..1, over the zone
+	TrailTime    float64 // seconds still on the brake after turn-in
+	EntrySpeed   float64 // at the brake point
+	MinSpeed     float64 // lowest speed between brake point and release
+	Scrubbed     float64 // EntrySpeed - MinSpeed
+}
 
-	lapOnsets := make([][]onset, len(lapIdx)-1)
-	for lap := 0; lap < len(lapIdx)-1; lap++ {
-		start := lapIdx[lap]
-		end := lapIdx[lap+1]
-		if start < 0 || end > len(samples) || end <= start+1 {
-			continue
-		}
-		baseS := pts[start].S
-		prevBrake := 0.0
-		if samples[start].HasInputBrake {
-			prevBrake = clamp(float64(samples[start].Brake)/255.0, 0, 1)
-		}
-		for i := start + 1; i < end; i++ {
-			cur := samples[i]
-			curBrake := 0.0
-			if cur.HasInputBrake {
-				curBrake = clamp(float64(cur.Brake)/255.0, 0, 1)
-			}
-			trigger := false
-			if cur.HasInputBrake || samples[i-1].HasInputBrake {
-				if prevBrake <= brakeLow && curBrake >= brakeHigh {
-					trigger = true
-				}
-			} else {
-				// fallback to decel
-				if samples[i-1].AccelX >= accelThresh && cur.AccelX < accelThresh {
-					trigger = true
-				}
-			}
-			if trigger {
-				relS := pts[i].S - baseS
-				lapOnsets[lap] = append(lapOnsets[lap], onset{idx: i, relS: relS})
-			}
-			prevBrake = curBrake
-		}
+// BrakeZoneFor finds the braking zone leading into corners[i] on one lap: the zone
+// around the hardest braking between the previous corner's exit and this corner's
+// minimum-speed point, so an extra dab of brake elsewhere cannot shift it. ok is false
+// when the lap does not brake for the corner.
+func BrakeZoneFor(lap []PhaseSample, corners []CornerDef, i int) (BrakeZone, bool) {
+	first, last, minIdx, ok := cornerSpan(lap, corners, i)
+	if !ok {
+		return BrakeZone{}, false
 	}
-
-	// Average positions by order index.
-	maxCount := 0
-	for _, l := range lapOnsets {
-		if len(l) > maxCount {
-			maxCount = len(l)
-		}
+	on, peak, off, ok := brakeZone(lap, first, minIdx, last)
+	if !ok {
+		return BrakeZone{}, false
 	}
-	if maxCount == 0 {
-		return nil
+	z := BrakeZone{
+		Corner:       i,
+		Point:        on,
+		PointS:       lap[on].RelS,
+		ReleaseS:     lap[off].RelS,
+		Distance:     lap[off].RelS - lap[on].RelS,
+		Duration:     lap[off].Time - lap[on].Time,
+		PeakPressure: lap[peak].Brake,
+		EntrySpeed:   lap[on].Speed,
+		MinSpeed:     lap[on].Speed,
 	}
-	means := make([]float64, maxCount)
-	counts := make([]int, maxCount)
-	for _, l := range lapOnsets {
-		for k, o := range l {
-			means[k] += o.relS
-			counts[k]++
+	var sum float64
+	for k := on; k <= off; k++ {
+		sum += lap[k].Brake
+		if lap[k].Speed < z.MinSpeed {
+			z.MinSpeed = lap[k].Speed
 		}
 	}
-	for k := range means {
-		if counts[k] > 0 {
-			means[k] /= float64(counts[k])
-		}
+	z.AvgPressure = sum / float64(off-on+1)
+	z.Scrubbed = z.EntrySpeed - z.MinSpeed
+	if turn, ok := turnIn(lap, corners[i], first, last, minIdx); ok && turn < off {
+		from := max(turn, on)
+		z.TrailTime = lap[off].Time - lap[from].Time
 	}
+	return z, true
+}
 
-	var events []models.Event
-	for _, l := range lapOnsets {
-		for k, o := range l {
-			if counts[k] == 0 {
-				continue
-			}
-			delta := o.relS - means[k]
-			if math.Abs(delta) < tolerance {
-				continue
-			}
-			typ := "late_brake"
-			if delta < 0 {
-				typ = "early_brake"
-			}
-			px, py := pts[o.idx].X, pts[o.idx].Y
-			mi, mRelS, mx, my, dist := MapRelSToMaster(master, o.relS, px, py)
-			ev := models.Event{
-				Index:      o.idx,
-				Time:       samples[o.idx].Time,
-				Type:       typ,
-				Note:       fmt.Sprintf("brake %d: %+0.1fm vs avg", k+1, delta),
-				MasterIdx:  mi,
-				MasterRelS: mRelS,
-				MasterX:    mx,
-				MasterY:    my,
-				DistanceSq: dist,
-			}
-			events = append(events, ev)
-		}
+// JudgeBrakePoint returns "early_brake" or "late_brake" when pointS lies more than
+// BrakeTolerance before or after the reference brake point, or "" otherwise.
+func JudgeBrakePoint(pointS, refS float64) string {
+	switch d := pointS - refS; {
+	case d <= -BrakeTolerance:
+		return "early_brake"
+	case d >= BrakeTolerance:
+		return "late_brake"
 	}
-
-	return events
+	return ""
 }
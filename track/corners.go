@@ -1,44 +1,106 @@
 package track
 
 import (
+	"fmt"
 	"forza/models"
 	"math"
+	"strconv"
+	"strings"
 )
 
 // CornerDef describes a detected corner along the master lap.
+// Index is the position along the lap and may shift when detection splits or
+// merges a bend; ID is stable across re-detection and Name is user supplied.
 type CornerDef struct {
-	Index     int
-	StartS    float64
-	EndS      float64
-	ApexS     float64
-	Direction string
-	AngleRad  float64
+	Index     int     `json:"index"`
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	StartS    float64 `json:"startS"`
+	EndS      float64 `json:"endS"`
+	ApexS     float64 `json:"apexS"`
+	ApexX     float64 `json:"apexX,omitempty"` // world position of the apex
+	ApexY     float64 `json:"apexY,omitempty"`
+	Direction string  `json:"direction"`
+	AngleRad  float64 `json:"angleRad"`
+	// Classification (see ClassifyCorners).
+	Type       string  `json:"type,omitempty"`       // hairpin, sweeper, kink, chicane, complex or corner
+	RadiusM    float64 `json:"radiusM,omitempty"`    // tightest radius through the corner (m)
+	Apexes     int     `json:"apexes,omitempty"`     // curvature peaks merged into this corner
+	Difficulty float64 `json:"difficulty,omitempty"` // 0 (flat out) .. 10
 }
 
-// DetectCorners identifies corners on the master lap using curvature with smoothing and merging.
-// Returns coarse start/end/apex positions; callers can refine metrics per lap.
-func DetectCorners(master []models.Trackpoint) []CornerDef {
-	if len(master) < 5 {
+// Corner types assigned by ClassifyCorners.
+const (
+	CornerHairpin = "hairpin"
+	CornerSweeper = "sweeper"
+	CornerKink    = "kink"
+	CornerChicane = "chicane"
+	CornerComplex = "complex"
+	CornerPlain   = "corner"
+)
+
+// SegmentDef is a corner or straight section of the master lap.
+type SegmentDef struct {
+	Type       string  `json:"type"` // corner or straight
+	StartS     float64 `json:"startS"`
+	EndS       float64 `json:"endS"`
+	Corner     string  `json:"corner,omitempty"`     // corner ID for corner segments
+	CornerType string  `json:"cornerType,omitempty"` // corner classification for corner segments
+}
+
+// BuildSegments splits the master lap into alternating straights and corners.
+func BuildSegments(master []models.Trackpoint, corners []CornerDef) []SegmentDef {
+	if len(master) == 0 {
 		return nil
 	}
+	var segments []SegmentDef
+	lastEnd := 0.0
+	masterLen := master[len(master)-1].S
+	for _, c := range corners {
+		if c.StartS > lastEnd {
+			segments = append(segments, SegmentDef{Type: "straight", StartS: lastEnd, EndS: c.StartS})
+		}
+		segments = append(segments, SegmentDef{Type: "corner", StartS: c.StartS, EndS: c.EndS, Corner: c.ID, CornerType: c.Type})
+		lastEnd = c.EndS
+	}
+	if lastEnd < masterLen {
+		segments = append(segments, SegmentDef{Type: "straight", StartS: lastEnd, EndS: masterLen})
+	}
+	return segments
+}
 
-	// Compute curvature (delta heading over distance) and smooth to avoid chatter.
-	curv := make([]float64, len(master))
-	for i := 1; i < len(master)-1; i++ {
-		dTheta := wrapAngle(master[i+1].Theta - master[i-1].Theta)
-		dS := master[i+1].S - master[i-1].S
-		if dS != 0 {
-			curv[i] = dTheta / dS
+// TagSegments copies corner IDs and types onto the corner segments they cover, for
+// segment lists that were stored before corners were classified or renamed.
+func TagSegments(segments []SegmentDef, corners []CornerDef) {
+	for i := range segments {
+		if segments[i].Type != "corner" {
+			continue
+		}
+		mid := (segments[i].StartS + segments[i].EndS) / 2
+		for _, c := range corners {
+			if mid >= c.StartS && mid <= c.EndS {
+				segments[i].Corner = c.ID
+				segments[i].CornerType = c.Type
+				break
+			}
 		}
 	}
-	curv = smoothCurv(curv, 5)
+}
+
+// DetectCorners identifies corners on the master lap using curvature with smoothing and merging.
+// Returns coarse start/end/apex positions, classified by ClassifyCorners; callers can
+// refine metrics per lap.
+func DetectCorners(master []models.Trackpoint) []CornerDef {
+	if len(master) < 5 {
+		return nil
+	}
+	curv := masterCurvature(master)
 
 	var raw []CornerDef
 	const onThresh = 0.006  // rad/m
 	const offThresh = 0.004 // hysteresis
 	const minAngle = 0.12   // rad (~7 deg)
 	const minLen = 8.0      // meters
-	const minGap = 12.0     // meters between corners
 	const mergeGap = 25.0   // merge close same-direction segments
 
 	inCorner := false
@@ -77,6 +139,8 @@ func DetectCorners(master []models.Trackpoint) []CornerDef {
 						ApexS:     master[maxIdx].S,
 						Direction: dir,
 						AngleRad:  angle,
+						RadiusM:   1 / math.Abs(maxCurv),
+						Apexes:    1,
 					})
 				}
 				inCorner = false
@@ -84,7 +148,8 @@ func DetectCorners(master []models.Trackpoint) []CornerDef {
 		}
 	}
 
-	// Merge close same-direction segments to avoid splitting shallow bends.
+	// Merge close same-direction segments into one multi-apex corner. Close segments
+	// turning the other way stay separate; ClassifyCorners pairs them as a chicane.
 	var merged []CornerDef
 	for _, c := range raw {
 		if len(merged) == 0 {
@@ -95,16 +160,10 @@ func DetectCorners(master []models.Trackpoint) []CornerDef {
 		if c.Direction == last.Direction && c.StartS-last.EndS < mergeGap {
 			last.EndS = c.EndS
 			last.AngleRad += c.AngleRad
-			if math.Abs(c.AngleRad) > math.Abs(last.AngleRad) {
-				last.ApexS = c.ApexS
-			}
-			continue
-		}
-		if c.StartS-last.EndS < minGap {
-			last.EndS = c.EndS
-			last.AngleRad += c.AngleRad
-			if math.Abs(c.AngleRad) > math.Abs(last.AngleRad) {
+			last.Apexes += c.Apexes
+			if c.RadiusM < last.RadiusM {
 				last.ApexS = c.ApexS
+				last.RadiusM = c.RadiusM
 			}
 			continue
 		}
@@ -114,9 +173,154 @@ func DetectCorners(master []models.Trackpoint) []CornerDef {
 	for i := range merged {
 		merged[i].Index = i
 	}
+	ClassifyCorners(merged, master)
 	return merged
 }
 
+// ClassifyCorners assigns each corner a type, radius and difficulty from its angle,
+// tightest radius and length. Consecutive corners turning opposite ways with only a
+// short gap between them are both typed as a chicane; same-direction corners with
+// several apexes are a complex. Radius is taken from the master when missing (e.g.
+// corners stored before classification existed).
+func ClassifyCorners(corners []CornerDef, master []models.Trackpoint) {
+	const chicaneGap = 25.0 // meters between the two halves of a chicane
+	var curv []float64
+	for i := range corners {
+		c := &corners[i]
+		if c.Apexes < 1 {
+			c.Apexes = 1
+		}
+		if c.RadiusM <= 0 && len(master) >= 5 {
+			if curv == nil {
+				curv = masterCurvature(master)
+			}
+			peak := 0.0
+			for j, p := range master {
+				if p.S >= c.StartS && p.S <= c.EndS {
+					peak = math.Max(peak, math.Abs(curv[j]))
+				}
+			}
+			if peak > 0 {
+				c.RadiusM = 1 / peak
+			}
+		}
+		c.Type = ""
+	}
+	for i := 0; i+1 < len(corners); i++ {
+		a, b := &corners[i], &corners[i+1]
+		if a.Direction != b.Direction && b.StartS-a.EndS < chicaneGap {
+			a.Type, b.Type = CornerChicane, CornerChicane
+		}
+	}
+	for i := range corners {
+		c := &corners[i]
+		deg := math.Abs(c.AngleRad) * 180 / math.Pi
+		switch {
+		case c.Type == CornerChicane:
+		case c.Apexes > 1:
+			c.Type = CornerComplex
+		case deg >= 120 || (deg >= 90 && c.RadiusM > 0 && c.RadiusM < 20):
+			c.Type = CornerHairpin
+		case deg < 25:
+			c.Type = CornerKink
+		case c.RadiusM >= 80:
+			c.Type = CornerSweeper
+		default:
+			c.Type = CornerPlain
+		}
+		c.Difficulty = cornerDifficulty(*c, deg)
+	}
+}
+
+// cornerDifficulty scores 0..10: tight, long-turning corners score high, and
+// direction changes or several apexes add to it. A radius above ~250 m is flat out.
+func cornerDifficulty(c CornerDef, deg float64) float64 {
+	tight := 0.0
+	if c.RadiusM > 0 {
+		tight = math.Min(1, 20/c.RadiusM)
+	}
+	turn := math.Min(1, deg/180)
+	extra := 0.0
+	switch c.Type {
+	case CornerChicane:
+		extra = 1
+	case CornerComplex:
+		extra = math.Min(1, 0.5*float64(c.Apexes-1))
+	}
+	score := 10 * (0.5*tight + 0.3*turn + 0.2*extra)
+	if c.RadiusM > 250 && extra == 0 {
+		score = math.Min(score, 1)
+	}
+	return math.Round(score*10) / 10
+}
+
+// masterCurvature returns smoothed curvature (delta heading over distance, rad/m)
+// at each master point.
+func masterCurvature(master []models.Trackpoint) []float64 {
+	curv := make([]float64, len(master))
+	for i := 1; i < len(master)-1; i++ {
+		dTheta := wrapAngle(master[i+1].Theta - master[i-1].Theta)
+		dS := master[i+1].S - master[i-1].S
+		if dS != 0 {
+			curv[i] = dTheta / dS
+		}
+	}
+	return smoothCurv(curv, 5)
+}
+
+// AnchorCorners records each corner's apex in world coordinates, taken from the
+// master (built in the frame offset by frameX, frameY) at ApexS.
+func AnchorCorners(corners []CornerDef, master []models.Trackpoint, frameX, frameY float64) {
+	if len(master) == 0 {
+		return
+	}
+	for i := range corners {
+		j := 0
+		for j+1 < len(master) && master[j+1].S <= corners[i].ApexS {
+			j++
+		}
+		corners[i].ApexX = master[j].X + frameX
+		corners[i].ApexY = master[j].Y + frameY
+	}
+}
+
+// MatchCornerIDs gives each corner the ID and name of the closest stored corner
+// turning the same way whose apex lies within tol meters; stored corners are used at
+// most once. Unmatched corners get fresh IDs ("T<n>") numbered after the stored ones.
+// Corners must be anchored (see AnchorCorners) first.
+func MatchCornerIDs(corners, stored []CornerDef, tol float64) {
+	next := 1
+	for _, c := range stored {
+		if n, err := strconv.Atoi(strings.TrimPrefix(c.ID, "T")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	taken := make([]bool, len(stored))
+	for i := range corners {
+		best, bestD := -1, tol*tol
+		for j, sc := range stored {
+			if taken[j] || sc.Direction != corners[i].Direction {
+				continue
+			}
+			dx := sc.ApexX - corners[i].ApexX
+			dy := sc.ApexY - corners[i].ApexY
+			if d := dx*dx + dy*dy; d <= bestD {
+				best, bestD = j, d
+			}
+		}
+		if best >= 0 {
+			taken[best] = true
+			corners[i].Name = stored[best].Name
+			if stored[best].ID != "" {
+				corners[i].ID = stored[best].ID
+				continue
+			}
+		}
+		corners[i].ID = fmt.Sprintf("T%d", next)
+		next++
+	}
+}
+
 func smoothCurv(vals []float64, window int) []float64 {
 	if window <= 1 || len(vals) == 0 {
 		return vals
@@ -0,0 +1,78 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"strconv"
+	"strings"
+)
+
+// SessionBound marks one end of a crop window, either as seconds from the first
+// sample or as a 1-based lap number taken from the telemetry LapNumber channel.
+type SessionBound struct {
+	Seconds float64
+	Lap     int
+	Set     bool
+}
+
+// ParseSessionBound accepts "" (unset), a number of seconds ("95.5") or a lap ("lap:3").
+func ParseSessionBound(v string) (SessionBound, error) {
+	v = strings.TrimSpace(strings.ToLower(v))
+	if v == "" {
+		return SessionBound{}, nil
+	}
+	if rest, ok := strings.CutPrefix(v, "lap:"); ok {
+		lap, err := strconv.Atoi(rest)
+		if err != nil || lap < 1 {
+			return SessionBound{}, fmt.Errorf("invalid lap bound %q", v)
+		}
+		return SessionBound{Lap: lap, Set: true}, nil
+	}
+	sec, err := strconv.ParseFloat(v, 64)
+	if err != nil || sec < 0 {
+		return SessionBound{}, fmt.Errorf("invalid time bound %q", v)
+	}
+	return SessionBound{Seconds: sec, Set: true}, nil
+}
+
+func (b SessionBound) String() string {
+	switch {
+	case !b.Set:
+		return ""
+	case b.Lap > 0:
+		return fmt.Sprintf("lap:%d", b.Lap)
+	}
+	return strconv.FormatFloat(b.Seconds, 'f', -1, 64)
+}
+
+// CropSamples keeps the samples between start and end. A lap start keeps that lap
+// onward; a lap end keeps everything through the end of that lap. Time bounds are
+// relative to the first sample. Returns an error when the window is empty.
+func CropSamples(samples []models.Sample, start, end SessionBound) ([]models.Sample, error) {
+	if len(samples) == 0 || (!start.Set && !end.Set) {
+		return samples, nil
+	}
+	t0 := samples[0].Time
+	lo, hi := 0, len(samples)
+	if start.Set {
+		lo = len(samples)
+		for i, s := range samples {
+			if (start.Lap > 0 && s.LapNumber >= start.Lap-1) || (start.Lap == 0 && s.Time-t0 >= start.Seconds) {
+				lo = i
+				break
+			}
+		}
+	}
+	if end.Set {
+		for i, s := range samples {
+			if (end.Lap > 0 && s.LapNumber >= end.Lap) || (end.Lap == 0 && s.Time-t0 > end.Seconds) {
+				hi = i
+				break
+			}
+		}
+	}
+	if hi-lo < 2 {
+		return nil, fmt.Errorf("crop window %s..%s leaves no samples", start, end)
+	}
+	return samples[lo:hi], nil
+}
@@ -0,0 +1,90 @@
+package track
+
+import "sort"
+
+// TimeCurve maps master distance to elapsed time for one lap. S is strictly
+// increasing master relS (m); T is seconds since the first point of the lap.
+type TimeCurve struct {
+	S []float64 `json:"s"`
+	T []float64 `json:"t"`
+}
+
+// BuildTimeCurve builds a curve from per-point master relS and timestamps, dropping
+// points that do not advance along the master (clamped or stationary samples).
+func BuildTimeCurve(relS, times []float64) TimeCurve {
+	var c TimeCurve
+	n := len(relS)
+	if len(times) < n {
+		n = len(times)
+	}
+	if n == 0 {
+		return c
+	}
+	t0 := times[0]
+	for i := 0; i < n; i++ {
+		if len(c.S) > 0 && relS[i] <= c.S[len(c.S)-1] {
+			continue
+		}
+		c.S = append(c.S, relS[i])
+		c.T = append(c.T, times[i]-t0)
+	}
+	return c
+}
+
+// Valid reports whether the curve spans any distance.
+func (c TimeCurve) Valid() bool {
+	return len(c.S) >= 2 && len(c.S) == len(c.T)
+}
+
+// TimeAt interpolates elapsed time at master distance s, clamping to the curve ends.
+func (c TimeCurve) TimeAt(s float64) float64 {
+	if !c.Valid() {
+		return 0
+	}
+	if s <= c.S[0] {
+		return c.T[0]
+	}
+	last := len(c.S) - 1
+	if s >= c.S[last] {
+		return c.T[last]
+	}
+	hi := sort.SearchFloat64s(c.S, s)
+	lo := hi - 1
+	span := c.S[hi] - c.S[lo]
+	if span <= 0 {
+		return c.T[lo]
+	}
+	return c.T[lo] + (c.T[hi]-c.T[lo])*(s-c.S[lo])/span
+}
+
+// DeltaAt returns lap's time loss (positive = slower) against ref between the lap's
+// first point and master distance s. Measuring from the lap's own start keeps laps
+// that begin part-way along the master comparable.
+func DeltaAt(lap, ref TimeCurve, s float64) float64 {
+	if !lap.Valid() || !ref.Valid() {
+		return 0
+	}
+	s0 := lap.S[0]
+	return (lap.TimeAt(s) - lap.TimeAt(s0)) - (ref.TimeAt(s) - ref.TimeAt(s0))
+}
+
+// SectionDelta returns time gained (negative) or lost (positive) against ref between
+// master distances startS and endS.
+func SectionDelta(lap, ref TimeCurve, startS, endS float64) float64 {
+	if !lap.Valid() || !ref.Valid() {
+		return 0
+	}
+	return (lap.TimeAt(endS) - lap.TimeAt(startS)) - (ref.TimeAt(endS) - ref.TimeAt(startS))
+}
+
+// SectorTimes returns the time between consecutive sector gates (master relS).
+func SectorTimes(lap TimeCurve, gates []float64) []float64 {
+	if !lap.Valid() || len(gates) < 2 {
+		return nil
+	}
+	out := make([]float64, len(gates)-1)
+	for i := range out {
+		out[i] = lap.TimeAt(gates[i+1]) - lap.TimeAt(gates[i])
+	}
+	return out
+}
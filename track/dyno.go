@@ -0,0 +1,159 @@
+package track
+
+import (
+	"forza/models"
+	"sort"
+)
+
+// Dyno curve and RPM histogram settings.
+const (
+	curveBinRPM     = 250 // rpm per dyno-curve bin
+	curveMinSamples = 3   // samples a bin needs
+	histBinRPM      = 500 // rpm per histogram bin
+	powerBandFrac   = 0.9 // power at or above this fraction of peak is the power band
+)
+
+// CurvePoint is one RPM bin of the engine curve. Power is in watts, torque in Nm.
+type CurvePoint struct {
+	RPM     float64
+	Power   float64
+	Torque  float64
+	Samples int
+}
+
+// Dyno is an engine curve rebuilt from telemetry, with its peaks and the power band
+// (the RPM range making at least powerBandFrac of peak power).
+type Dyno struct {
+	Curve         []CurvePoint
+	PeakPower     float64
+	PeakPowerRPM  float64
+	PeakTorque    float64
+	PeakTorqueRPM float64
+	BandLow       float64
+	BandHigh      float64
+}
+
+// RPMBin is the time spent in one RPM bin, RPM being the bin's lower edge.
+type RPMBin struct {
+	RPM  float64
+	Time float64
+}
+
+// GearHistogram is the time spent in each RPM bin in one gear, and how much of it was
+// inside the power band.
+type GearHistogram struct {
+	Gear     int
+	Time     float64
+	BandTime float64
+	Bins     []RPMBin
+}
+
+// DynoCurve bins power and torque against RPM at full throttle with the clutch out,
+// away from gear changes. Each bin takes the median, so shift transients and traction
+// loss do not drag it down.
+func DynoCurve(samples []models.Sample) []CurvePoint {
+	near := nearShift(samples)
+	power := make(map[int][]float64)
+	torque := make(map[int][]float64)
+	for i, s := range samples {
+		if near[i] || throttleOf(s) < shiftFullThrottle || s.Clutch > 25 || !driveGear(s.Gear) || s.Power <= 0 || s.EngineCurrentRPM <= 0 {
+			continue
+		}
+		b := int(s.EngineCurrentRPM / curveBinRPM)
+		power[b] = append(power[b], s.Power)
+		torque[b] = append(torque[b], s.Torque)
+	}
+	var out []CurvePoint
+	for b, p := range power {
+		if len(p) < curveMinSamples {
+			continue
+		}
+		t := torque[b]
+		sort.Float64s(p)
+		sort.Float64s(t)
+		out = append(out, CurvePoint{
+			RPM:     (float64(b) + 0.5) * curveBinRPM,
+			Power:   p[len(p)/2],
+			Torque:  t[len(t)/2],
+			Samples: len(p),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].RPM < out[j].RPM })
+	return out
+}
+
+// curveAt interpolates power on the curve at rpm; ok is false outside the measured range.
+func curveAt(curve []CurvePoint, rpm float64) (float64, bool) {
+	if len(curve) == 0 || rpm < curve[0].RPM || rpm > curve[len(curve)-1].RPM {
+		return 0, false
+	}
+	i := sort.Search(len(curve), func(i int) bool { return curve[i].RPM >= rpm })
+	if i == 0 || curve[i].RPM == rpm {
+		return curve[i].Power, true
+	}
+	a, b := curve[i-1], curve[i]
+	f := (rpm - a.RPM) / (b.RPM - a.RPM)
+	return a.Power + f*(b.Power-a.Power), true
+}
+
+// BuildDyno rebuilds the engine curve and finds its peaks and power band.
+func BuildDyno(samples []models.Sample) Dyno {
+	d := Dyno{Curve: DynoCurve(samples)}
+	for _, p := range d.Curve {
+		if p.Power > d.PeakPower {
+			d.PeakPower, d.PeakPowerRPM = p.Power, p.RPM
+		}
+		if p.Torque > d.PeakTorque {
+			d.PeakTorque, d.PeakTorqueRPM = p.Torque, p.RPM
+		}
+	}
+	// The band is the contiguous run of bins around the power peak.
+	for k, p := range d.Curve {
+		if p.RPM != d.PeakPowerRPM {
+			continue
+		}
+		lo, hi := k, k
+		for lo > 0 && d.Curve[lo-1].Power >= powerBandFrac*d.PeakPower {
+			lo--
+		}
+		for hi < len(d.Curve)-1 && d.Curve[hi+1].Power >= powerBandFrac*d.PeakPower {
+			hi++
+		}
+		d.BandLow = d.Curve[lo].RPM - curveBinRPM/2
+		d.BandHigh = d.Curve[hi].RPM + curveBinRPM/2
+	}
+	return d
+}
+
+// RPMHistogram totals the time spent in each RPM bin per forward gear. Time inside
+// [bandLow, bandHigh) also counts toward BandTime.
+func RPMHistogram(samples []models.Sample, bandLow, bandHigh float64) []GearHistogram {
+	byGear := make(map[int]map[int]float64)
+	band := make(map[int]float64)
+	for k := 0; k+1 < len(samples); k++ {
+		s := samples[k]
+		dt := samples[k+1].Time - s.Time
+		if dt <= 0 || dt > 1 || !driveGear(s.Gear) || s.EngineCurrentRPM <= 0 {
+			continue
+		}
+		if byGear[s.Gear] == nil {
+			byGear[s.Gear] = make(map[int]float64)
+		}
+		byGear[s.Gear][int(s.EngineCurrentRPM/histBinRPM)] += dt
+		if bandHigh > bandLow && s.EngineCurrentRPM >= bandLow && s.EngineCurrentRPM < bandHigh {
+			band[s.Gear] += dt
+		}
+	}
+	out := make([]GearHistogram, 0, len(byGear))
+	for g, bins := range byGear {
+		h := GearHistogram{Gear: g, BandTime: band[g]}
+		for b, t := range bins {
+			h.Time += t
+			h.Bins = append(h.Bins, RPMBin{RPM: float64(b) * histBinRPM, Time: t})
+		}
+		sort.Slice(h.Bins, func(i, j int) bool { return h.Bins[i].RPM < h.Bins[j].RPM })
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Gear < out[j].Gear })
+	return out
+}
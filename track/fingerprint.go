@@ -0,0 +1,164 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const signatureBins = 72
+
+// MatchThresholds bound how far a session may differ from a stored track and still
+// be identified as it. Zero fields fall back to DefaultMatch.
+type MatchThresholds struct {
+	MaxLengthDiff    float64 `json:"maxLengthDiff,omitempty"`    // fraction of lap length
+	MaxSignatureDiff float64 `json:"maxSignatureDiff,omitempty"` // normalised turning-profile difference
+}
+
+// DefaultMatch are the thresholds used when neither the library nor the caller sets any.
+var DefaultMatch = MatchThresholds{MaxLengthDiff: 0.08, MaxSignatureDiff: 0.35}
+
+// Or fills m's unset fields from def.
+func (m MatchThresholds) Or(def MatchThresholds) MatchThresholds {
+	if m.MaxLengthDiff <= 0 {
+		m.MaxLengthDiff = def.MaxLengthDiff
+	}
+	if m.MaxSignatureDiff <= 0 {
+		m.MaxSignatureDiff = def.MaxSignatureDiff
+	}
+	return m
+}
+
+// ParseMatchThresholds reads "<length>,<signature>" (e.g. "0.05,0.25"); either part
+// may be left empty to keep its default.
+func ParseMatchThresholds(spec string) (MatchThresholds, error) {
+	var m MatchThresholds
+	if strings.TrimSpace(spec) == "" {
+		return m, nil
+	}
+	parts := strings.Split(spec, ",")
+	if len(parts) > 2 {
+		return m, fmt.Errorf("track match %q: want <length>,<signature>", spec)
+	}
+	fields := []*float64{&m.MaxLengthDiff, &m.MaxSignatureDiff}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v <= 0 {
+			return m, fmt.Errorf("track match %q: thresholds must be positive numbers", spec)
+		}
+		*fields[i] = v
+	}
+	return m, nil
+}
+
+// Fingerprint summarises a track's shape so sessions can be matched against a
+// library of stored tracks: where it starts, which way it points, how long it is,
+// and how much it turns along its length (signed corner angle per distance bin).
+type Fingerprint struct {
+	StartX       float64   `json:"startX"`
+	StartY       float64   `json:"startY"`
+	StartHeading float64   `json:"startHeading"`
+	Length       float64   `json:"length"`
+	Sprint       bool      `json:"sprint,omitempty"`
+	Signature    []float64 `json:"signature"`
+}
+
+// FingerprintOf builds a fingerprint from a master track in world coordinates and
+// the corners detected on it.
+func FingerprintOf(master []models.Trackpoint, corners []CornerDef, sprint bool) Fingerprint {
+	fp := Fingerprint{Sprint: sprint, Signature: make([]float64, signatureBins)}
+	if len(master) == 0 {
+		return fp
+	}
+	fp.StartX, fp.StartY, fp.StartHeading = master[0].X, master[0].Y, master[0].Theta
+	fp.Length = master[len(master)-1].S
+	if fp.Length <= 0 {
+		return fp
+	}
+	binLen := fp.Length / signatureBins
+	for _, c := range corners {
+		span := c.EndS - c.StartS
+		if span <= 0 {
+			continue
+		}
+		// Spread the corner's angle over the bins it covers.
+		for b := 0; b < signatureBins; b++ {
+			lo := math.Max(c.StartS, float64(b)*binLen)
+			hi := math.Min(c.EndS, float64(b+1)*binLen)
+			if hi > lo {
+				fp.Signature[b] += c.AngleRad * (hi - lo) / span
+			}
+		}
+	}
+	return fp
+}
+
+// Distance scores how different two fingerprints are (0 = identical) and reports
+// whether they plausibly describe the same track. Lapped tracks compare signatures
+// under every cyclic shift since sessions may start anywhere on the loop; start
+// position and heading only break ties. Unset limits fall back to DefaultMatch.
+func (a Fingerprint) Distance(b Fingerprint, limits MatchThresholds) (float64, bool) {
+	limits = limits.Or(DefaultMatch)
+	if a.Length <= 0 || b.Length <= 0 || len(a.Signature) != len(b.Signature) || a.Sprint != b.Sprint {
+		return math.Inf(1), false
+	}
+	longest := math.Max(a.Length, b.Length)
+	lenTerm := math.Abs(a.Length-b.Length) / longest
+
+	var norm float64
+	for i := range a.Signature {
+		norm += math.Abs(a.Signature[i]) + math.Abs(b.Signature[i])
+	}
+	sigTerm := 0.0
+	if norm > 0 {
+		// Sprints share a start/finish, so only allow a small slip of the start gate.
+		n := len(a.Signature)
+		lo, hi := 0, n-1
+		if a.Sprint {
+			lo, hi = -4, 4
+		}
+		sigTerm = math.Inf(1)
+		for k := lo; k <= hi; k++ {
+			var diff float64
+			for i := range a.Signature {
+				j := i + k
+				if a.Sprint && (j < 0 || j >= n) {
+					diff += math.Abs(a.Signature[i])
+					continue
+				}
+				diff += math.Abs(a.Signature[i] - b.Signature[(j%n+n)%n])
+			}
+			sigTerm = math.Min(sigTerm, diff/norm)
+		}
+	}
+
+	startTerm := math.Min(math.Hypot(a.StartX-b.StartX, a.StartY-b.StartY)/longest, 1)
+	headTerm := math.Abs(wrapAngle(a.StartHeading-b.StartHeading)) / math.Pi
+	score := sigTerm + 2*lenTerm + 0.1*(startTerm+headTerm)
+	return score, lenTerm <= limits.MaxLengthDiff && sigTerm <= limits.MaxSignatureDiff
+}
+
+// IdentifyTrack returns the index of the best matching library track and its score,
+// or -1 when nothing matches. A track's own Match thresholds win over limits.
+func IdentifyTrack(fp Fingerprint, library []TrackDef, limits MatchThresholds) (int, float64) {
+	best, bestScore := -1, math.Inf(1)
+	for i, def := range library {
+		if def.Fingerprint == nil {
+			continue
+		}
+		own := limits
+		if def.Match != nil {
+			own = def.Match.Or(limits)
+		}
+		if score, ok := fp.Distance(*def.Fingerprint, own); ok && score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best, bestScore
+}
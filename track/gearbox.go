@@ -0,0 +1,104 @@
+package track
+
+import (
+	"forza/models"
+	"math"
+	"sort"
+)
+
+// GearRatio is one forward gear as seen in telemetry. Ratio is the overall ratio
+// (gear times final drive): engine revolutions per wheel revolution. The two cannot be
+// told apart from telemetry alone.
+type GearRatio struct {
+	Gear        int
+	Ratio       float64
+	RPMPerSpeed float64 // rpm per m/s
+	TopSpeed    float64 // m/s at the redline
+	RPMDrop     float64 // rpm lost shifting up into the next gear at the redline
+	MaxRPM      float64 // highest RPM seen in the gear
+}
+
+// Gearbox is the estimated gearing of a car. TireRadius is the average rolling radius
+// (m) over the wheels that could be fitted; MaxSpeed is the fastest seen (m/s).
+type Gearbox struct {
+	Redline    float64
+	TireRadius float64
+	MaxSpeed   float64
+	Gears      []GearRatio
+}
+
+// EstimateGearbox works out each gear's overall ratio from engine RPM against wheel
+// rotation while the tyres roll freely, falling back to RPM per speed and the fitted
+// tyre radius when wheel rotation is missing. Top speeds are at EngineMaxRPM.
+func EstimateGearbox(samples []models.Sample) Gearbox {
+	var gb Gearbox
+	var redlines []float64
+	for _, s := range samples {
+		if s.EngineMaxRPM > 0 {
+			redlines = append(redlines, s.EngineMaxRPM)
+		}
+		gb.MaxSpeed = math.Max(gb.MaxSpeed, speedMPS(s))
+	}
+	if len(redlines) > 0 {
+		sort.Float64s(redlines)
+		gb.Redline = redlines[len(redlines)/2]
+	}
+	radii := TireRadii(samples)
+	n := 0
+	for _, r := range radii {
+		if r > 0 {
+			gb.TireRadius += r
+			n++
+		}
+	}
+	if n > 0 {
+		gb.TireRadius /= float64(n)
+	}
+
+	near := nearShift(samples)
+	ratios := make(map[int][]float64)
+	maxRPM := make(map[int]float64)
+	for i, s := range samples {
+		if !driveGear(s.Gear) {
+			continue
+		}
+		maxRPM[s.Gear] = math.Max(maxRPM[s.Gear], s.EngineCurrentRPM)
+		if near[i] || s.Clutch > 25 || s.EngineCurrentRPM <= 0 || speedMPS(s) < gearMinSpeed {
+			continue
+		}
+		var rot float64
+		wheels := 0
+		for w := range WheelNames {
+			if r := math.Abs(wheelRot(s, w)); r >= 1 && math.Abs(wheelTireSlip(s, w)) <= radiusMaxSlip {
+				rot += r
+				wheels++
+			}
+		}
+		if wheels > 0 {
+			ratios[s.Gear] = append(ratios[s.Gear], s.EngineCurrentRPM*2*math.Pi/60/(rot/float64(wheels)))
+		}
+	}
+
+	perSpeed := RPMPerSpeed(samples)
+	for g, k := range perSpeed {
+		gr := GearRatio{Gear: g, RPMPerSpeed: k, MaxRPM: maxRPM[g]}
+		if v := ratios[g]; len(v) >= curveMinSamples {
+			sort.Float64s(v)
+			gr.Ratio = v[len(v)/2]
+		} else if gb.TireRadius > 0 {
+			gr.Ratio = k * gb.TireRadius * 2 * math.Pi / 60
+		}
+		if gb.Redline > 0 {
+			gr.TopSpeed = gb.Redline / k
+		}
+		gb.Gears = append(gb.Gears, gr)
+	}
+	sort.Slice(gb.Gears, func(i, j int) bool { return gb.Gears[i].Gear < gb.Gears[j].Gear })
+	for i := 0; i+1 < len(gb.Gears); i++ {
+		g, next := gb.Gears[i], gb.Gears[i+1]
+		if next.Gear == g.Gear+1 && gb.Redline > 0 {
+			gb.Gears[i].RPMDrop = gb.Redline * (1 - next.RPMPerSpeed/g.RPMPerSpeed)
+		}
+	}
+	return gb
+}
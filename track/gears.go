@@ -0,0 +1,299 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+	"sort"
+)
+
+// Shift analysis thresholds.
+const (
+	shiftFullThrottle = 0.9  // throttle (0..1) for a shift to be judged against the optimum
+	shiftGapFrac      = 0.2  // power below this fraction of the pre-shift power is the torque gap
+	shiftGapWindow    = 0.5  // seconds searched either side of a gear change for the gap
+	shiftSettle       = 0.3  // seconds around a gear change excluded from ratio fits
+	shiftTolerance    = 250  // rpm either side of the optimum before early/late is called
+	limiterOn         = 0.97 // fraction of EngineMaxRPM that counts as on the limiter
+	limiterOff        = 0.93 // fraction the RPM must fall below to leave the limiter
+	limiterMinTime    = 0.15 // seconds on the limiter before it is reported
+	gearMinSpeed      = 5.0  // m/s
+)
+
+// Shift is one gear change. Speeds are m/s.
+type Shift struct {
+	Index     int // sample index of the first sample in the new gear
+	From      int
+	To        int
+	RPM       float64 // engine RPM just before the change
+	RPMAfter  float64 // once the new gear has caught
+	Throttle  float64 // 0..1 just before the change
+	Gap       float64 // seconds of lost drive around the change (torque gap)
+	SpeedDrop float64 // speed lost during the gap
+}
+
+// Up reports whether the shift is an upshift.
+func (s Shift) Up() bool { return s.To > s.From }
+
+// GearStats summarises the shifts out of one gear. OptimalRPM is the upshift RPM that
+// keeps the engine on the higher power, from the observed power curve and gear spacing;
+// zero when it cannot be worked out.
+type GearStats struct {
+	Gear         int
+	Upshifts     int
+	Downshifts   int
+	ShiftRPM     float64 // average upshift RPM
+	OptimalRPM   float64
+	DownshiftRPM float64 // average RPM before downshifting out of this gear
+	TorqueGap    float64 // average upshift gap (s)
+	SpeedDrop    float64 // average speed lost per upshift (m/s)
+	EarlyShifts  int
+	LateShifts   int
+}
+
+func throttleOf(s models.Sample) float64 {
+	if !s.HasInputAccel {
+		return 0
+	}
+	return float64(s.ThrottleRaw) / 255
+}
+
+func driveGear(g int) bool { return g >= 1 && g <= 10 }
+
+// DetectShifts finds gear changes between forward gears and measures each one.
+func DetectShifts(samples []models.Sample) []Shift {
+	var out []Shift
+	for k := 1; k < len(samples); k++ {
+		prev, cur := samples[k-1], samples[k]
+		if cur.Gear == prev.Gear || !driveGear(prev.Gear) || !driveGear(cur.Gear) {
+			continue
+		}
+		sh := Shift{Index: k, From: prev.Gear, To: cur.Gear, RPM: prev.EngineCurrentRPM, Throttle: throttleOf(prev)}
+
+		// Pre-shift power is the most the engine made shortly before the change.
+		var pre float64
+		for j := k - 1; j >= 0 && prev.Time-samples[j].Time <= shiftSettle; j-- {
+			pre = math.Max(pre, samples[j].Power)
+		}
+		a, b := k, k
+		if pre > 0 {
+			limit := shiftGapFrac * pre
+			for a > 0 && samples[a-1].Power < limit && cur.Time-samples[a-1].Time <= shiftGapWindow {
+				a--
+			}
+			for b < len(samples)-1 && samples[b].Power < limit && samples[b].Time-cur.Time <= shiftGapWindow {
+				b++
+			}
+			if a < b {
+				sh.Gap = samples[b].Time - samples[a].Time
+				before := speedMPS(samples[max(a-1, 0)])
+				low := before
+				for j := a; j <= b; j++ {
+					low = math.Min(low, speedMPS(samples[j]))
+				}
+				sh.SpeedDrop = before - low
+			}
+		}
+		// RPM often lags the gear channel by a sample.
+		sh.RPMAfter = samples[min(max(b, k+1), len(samples)-1)].EngineCurrentRPM
+		out = append(out, sh)
+	}
+	return out
+}
+
+// RPMPerSpeed returns each forward gear's engine RPM per m/s, the median over samples
+// in gear with the clutch out and away from gear changes.
+func RPMPerSpeed(samples []models.Sample) map[int]float64 {
+	near := nearShift(samples)
+	vals := make(map[int][]float64)
+	for i, s := range samples {
+		v := speedMPS(s)
+		if near[i] || !driveGear(s.Gear) || v < gearMinSpeed || s.Clutch > 25 || s.EngineCurrentRPM <= 0 {
+			continue
+		}
+		vals[s.Gear] = append(vals[s.Gear], s.EngineCurrentRPM/v)
+	}
+	out := make(map[int]float64, len(vals))
+	for g, v := range vals {
+		if len(v) < curveMinSamples {
+			continue
+		}
+		sort.Float64s(v)
+		out[g] = v[len(v)/2]
+	}
+	return out
+}
+
+// nearShift marks samples within shiftSettle of a gear change.
+func nearShift(samples []models.Sample) []bool {
+	var changes []float64
+	for k := 1; k < len(samples); k++ {
+		if samples[k].Gear != samples[k-1].Gear {
+			changes = append(changes, samples[k].Time)
+		}
+	}
+	out := make([]bool, len(samples))
+	c := 0
+	for i, s := range samples {
+		for c < len(changes) && changes[c] < s.Time-shiftSettle {
+			c++
+		}
+		out[i] = c < len(changes) && math.Abs(changes[c]-s.Time) <= shiftSettle
+	}
+	return out
+}
+
+// OptimalShiftRPM returns the RPM to leave a gear for the next one: the first RPM at
+// which the next gear, at the RPM it drops to (step = next/current RPM per speed),
+// makes at least as much power. The top of the curve when the next gear never does.
+func OptimalShiftRPM(curve []CurvePoint, step float64) float64 {
+	if len(curve) == 0 || step <= 0 || step >= 1 {
+		return 0
+	}
+	top := curve[len(curve)-1].RPM
+	for rpm := curve[0].RPM; rpm <= top; rpm += 50 {
+		here, ok := curveAt(curve, rpm)
+		if !ok {
+			continue
+		}
+		if next, ok := curveAt(curve, rpm*step); ok && next >= here {
+			return rpm
+		}
+	}
+	return top
+}
+
+// GearSummary groups shifts by the gear they leave and compares full-throttle upshifts
+// with the optimal shift RPM.
+func GearSummary(samples []models.Sample, shifts []Shift) []GearStats {
+	curve := DynoCurve(samples)
+	ratios := RPMPerSpeed(samples)
+	byGear := make(map[int]*GearStats)
+	get := func(g int) *GearStats {
+		if byGear[g] == nil {
+			byGear[g] = &GearStats{Gear: g}
+			if r, next := ratios[g], ratios[g+1]; r > 0 && next > 0 {
+				byGear[g].OptimalRPM = OptimalShiftRPM(curve, next/r)
+			}
+		}
+		return byGear[g]
+	}
+	for _, sh := range shifts {
+		st := get(sh.From)
+		if !sh.Up() {
+			st.Downshifts++
+			st.DownshiftRPM += sh.RPM
+			continue
+		}
+		st.Upshifts++
+		st.ShiftRPM += sh.RPM
+		st.TorqueGap += sh.Gap
+		st.SpeedDrop += sh.SpeedDrop
+		switch JudgeShift(sh, st.OptimalRPM) {
+		case "early_shift":
+			st.EarlyShifts++
+		case "late_shift":
+			st.LateShifts++
+		}
+	}
+	out := make([]GearStats, 0, len(byGear))
+	for _, st := range byGear {
+		if n := float64(st.Upshifts); n > 0 {
+			st.ShiftRPM /= n
+			st.TorqueGap /= n
+			st.SpeedDrop /= n
+		}
+		if st.Downshifts > 0 {
+			st.DownshiftRPM /= float64(st.Downshifts)
+		}
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Gear < out[j].Gear })
+	return out
+}
+
+// JudgeShift returns "early_shift" or "late_shift" for a full-throttle upshift more
+// than shiftTolerance away from the optimal RPM, or "" otherwise.
+func JudgeShift(sh Shift, optimal float64) string {
+	if !sh.Up() || optimal <= 0 || sh.Throttle < shiftFullThrottle {
+		return ""
+	}
+	switch d := sh.RPM - optimal; {
+	case d <= -shiftTolerance:
+		return "early_shift"
+	case d >= shiftTolerance:
+		return "late_shift"
+	}
+	return ""
+}
+
+// ShiftEvents emits an "upshift" or "downshift" event per shift, plus "early_shift" or
+// "late_shift" where a full-throttle upshift missed the optimal RPM for its gear.
+func ShiftEvents(samples []models.Sample, shifts []Shift, gears []GearStats) []models.Event {
+	optimal := make(map[int]float64, len(gears))
+	for _, g := range gears {
+		optimal[g.Gear] = g.OptimalRPM
+	}
+	var events []models.Event
+	for _, sh := range shifts {
+		typ := "downshift"
+		if sh.Up() {
+			typ = "upshift"
+		}
+		note := fmt.Sprintf("%d→%d at %.0f rpm (to %.0f)", sh.From, sh.To, sh.RPM, sh.RPMAfter)
+		if sh.Gap > 0 {
+			note += fmt.Sprintf(", gap %.2fs, -%.1f mph", sh.Gap, sh.SpeedDrop*2.23694)
+		}
+		ev := models.Event{Index: sh.Index, Time: samples[sh.Index].Time, Type: typ, Note: note, Duration: sh.Gap}
+		events = append(events, ev)
+		if j := JudgeShift(sh, optimal[sh.From]); j != "" {
+			ev.Type = j
+			ev.Note = fmt.Sprintf("%d→%d at %.0f rpm, optimal %.0f", sh.From, sh.To, sh.RPM, optimal[sh.From])
+			ev.Duration = 0
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// DetectLimiter emits a "limiter" event wherever the engine sits on the rev limiter
+// under throttle for at least limiterMinTime.
+func DetectLimiter(samples []models.Sample) []models.Event {
+	var events []models.Event
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			if dur := samples[end].Time - samples[start].Time; dur >= limiterMinTime {
+				events = append(events, models.Event{
+					Index:    start,
+					Time:     samples[start].Time,
+					Type:     "limiter",
+					Note:     fmt.Sprintf("on the limiter in gear %d for %.1fs", samples[start].Gear, dur),
+					Duration: dur,
+				})
+			}
+		}
+		start = -1
+	}
+	for i, s := range samples {
+		if s.EngineMaxRPM <= 0 {
+			flush(max(i-1, 0))
+			continue
+		}
+		frac := s.EngineCurrentRPM / s.EngineMaxRPM
+		on := throttleOf(s) >= shiftFullThrottle
+		switch {
+		case start < 0 && on && frac >= limiterOn:
+			start = i
+		case start >= 0 && (!on || frac < limiterOff || s.Gear != samples[start].Gear):
+			flush(i - 1)
+			if on && frac >= limiterOn {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		flush(len(samples) - 1)
+	}
+	return events
+}
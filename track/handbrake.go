@@ -0,0 +1,93 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+)
+
+// Handbrake and clutch thresholds. Both inputs are 0..255 in the telemetry.
+const (
+	handbrakeOn      = 0.1  // handbrake (0..1) that counts as pulled
+	handbrakeMinTime = 0.05 // seconds; shorter blips are noise
+	clutchIn         = 0.5  // clutch (0..1) pressed far enough for a kick
+	clutchOut        = 0.1  // clutch (0..1) released again
+	clutchKickMax    = 0.5  // seconds a kick may last; longer is a shift or a stall
+	clutchThrottleOn = 0.5  // throttle (0..1) held through the kick
+	clutchMinSpeed   = 5.0  // m/s
+)
+
+// DetectHandbrake emits a "handbrake" event for each pull, carrying its duration and
+// the speed it was pulled at.
+func DetectHandbrake(samples []models.Sample) []models.Event {
+	var events []models.Event
+	start := -1
+	peak := 0.0
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		dur := samples[end].Time - samples[start].Time
+		if dur >= handbrakeMinTime {
+			events = append(events, models.Event{
+				Index:    start,
+				Time:     samples[start].Time,
+				Type:     "handbrake",
+				Note:     fmt.Sprintf("handbrake %.1fs at %.0f mph (%.0f%%)", dur, speedMPS(samples[start])*2.23694, peak*100),
+				Duration: dur,
+			})
+		}
+		start, peak = -1, 0
+	}
+	for i, s := range samples {
+		h := float64(s.Handbrake) / 255
+		if h < handbrakeOn {
+			flush(max(i-1, 0))
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		peak = math.Max(peak, h)
+	}
+	if start >= 0 {
+		flush(len(samples) - 1)
+	}
+	return events
+}
+
+// DetectClutchKicks emits a "clutch_kick" event where the clutch is stabbed in and
+// out within clutchKickMax while the throttle stays down and the gear does not
+// change, the drift technique for breaking the rear loose. Clutch presses around a
+// gear change are shifts and are ignored.
+func DetectClutchKicks(samples []models.Sample) []models.Event {
+	var events []models.Event
+	start := -1
+	for i, s := range samples {
+		c := float64(s.Clutch) / 255
+		switch {
+		case start < 0 && c >= clutchIn:
+			start = i
+		case start >= 0 && c <= clutchOut:
+			a := samples[start]
+			dur := s.Time - a.Time
+			kick := dur <= clutchKickMax && speedMPS(a) >= clutchMinSpeed
+			for k := start; kick && k <= i; k++ {
+				if samples[k].Gear != a.Gear || !samples[k].HasInputAccel || float64(samples[k].ThrottleRaw)/255 < clutchThrottleOn {
+					kick = false
+				}
+			}
+			if kick {
+				events = append(events, models.Event{
+					Index:    start,
+					Time:     a.Time,
+					Type:     "clutch_kick",
+					Note:     fmt.Sprintf("clutch kick in gear %d at %.0f rpm", a.Gear, a.EngineCurrentRPM),
+					Duration: dur,
+				})
+			}
+			start = -1
+		}
+	}
+	return events
+}
@@ -111,6 +111,93 @@ func BuildLapIdx(trackPoints []models.Trackpoint, laps int, lapLen float64, lapT
 	return BuildEvenLapIdx(trackPoints, laps)
 }
 
+// Lap types assigned by ClassifyLaps.
+const (
+	LapOut     = "out"
+	LapFlying  = "flying"
+	LapIn      = "in"
+	LapPartial = "partial"
+)
+
+// ClassifyLaps labels each lap in lapIdx as out, flying, in or partial by comparing
+// its length and start/end speed against the typical lap of the session.
+// The first lap is an out-lap when it is off-length (rolling start, formation) or
+// starts well below the usual crossing speed; the last lap is partial when short
+// (abandoned) and an in-lap when long or finishing slowly. Middle laps that are
+// off-length are partial. Sessions with a single lap are always flying.
+func ClassifyLaps(samples []models.Sample, points []models.Trackpoint, lapIdx []int) []string {
+	if len(lapIdx) < 2 {
+		return nil
+	}
+	n := len(lapIdx) - 1
+	out := make([]string, n)
+	for i := range out {
+		out[i] = LapFlying
+	}
+	if n < 2 || len(samples) == 0 || len(points) == 0 {
+		return out
+	}
+
+	const lenTol = 0.1    // fraction of reference lap length
+	const speedFrac = 0.5 // fraction of reference crossing speed
+
+	lens := make([]float64, n)
+	startSpd := make([]float64, n)
+	endSpd := make([]float64, n)
+	for i := 0; i < n; i++ {
+		start := lapIdx[i]
+		end := lapIdx[i+1]
+		if end > len(points) {
+			end = len(points)
+		}
+		if end > len(samples) {
+			end = len(samples)
+		}
+		if start < 0 || end <= start {
+			continue
+		}
+		lens[i] = points[end-1].S - points[start].S
+		startSpd[i] = speedMPS(samples[start])
+		endSpd[i] = speedMPS(samples[end-1])
+	}
+
+	// Middle laps are the most trustworthy reference; with only two laps the
+	// longer one is the best guess at a full lap.
+	var refLen float64
+	if n >= 3 {
+		refLen = median(lens[1 : n-1])
+	} else {
+		refLen = math.Max(lens[0], lens[1])
+	}
+	refStart := median(startSpd[1:])
+	refEnd := median(endSpd[:n-1])
+	if refLen <= 0 {
+		return out
+	}
+
+	for i := 0; i < n; i++ {
+		ratio := lens[i] / refLen
+		offLen := ratio < 1-lenTol || ratio > 1+lenTol
+		switch {
+		case i == 0:
+			if offLen || (refStart > 0 && startSpd[i] < refStart*speedFrac) {
+				out[i] = LapOut
+			}
+		case i == n-1:
+			if ratio < 1-lenTol {
+				out[i] = LapPartial
+			} else if ratio > 1+lenTol || (refEnd > 0 && endSpd[i] < refEnd*speedFrac) {
+				out[i] = LapIn
+			}
+		default:
+			if offLen {
+				out[i] = LapPartial
+			}
+		}
+	}
+	return out
+}
+
 func median(vals []float64) float64 {
 	if len(vals) == 0 {
 		return 0
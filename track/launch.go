@@ -0,0 +1,159 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+	"strings"
+)
+
+// Launch thresholds.
+const (
+	launchThrottle     = 0.8 // throttle (0..1) that counts as launching
+	launchMoving       = 0.5 // m/s; the car is rolling
+	launchSpinReported = 0.3 // seconds of wheelspin before a launch_wheelspin event
+	launchLift         = 0.5 // throttle (0..1) below this once rolling ends the launch
+	launchBrake        = 0.1 // brake (0..1) at or above this once rolling ends the launch
+	launchMaxTime      = 30  // seconds after the signal the 0-100/0-200 search gives up
+	kmh100             = 27.7778
+	kmh200             = 55.5556
+)
+
+// Launch describes a race start. Times are seconds from the start signal (IsRaceOn
+// going from 0 to 1); zero when not reached. Reaction and Held need the throttle
+// channel and are unset without it (Throttle false).
+type Launch struct {
+	Index         int     // sample index of the start signal
+	Time          float64 // session time of the start signal
+	Throttle      bool    // the session records throttle input
+	Reaction      float64 // start signal to launch throttle
+	Held          bool    // throttle was already down at the signal
+	MoveTime      float64 // start signal to the car rolling
+	LaunchRPM     float64 // engine RPM as the car starts rolling
+	Wheelspins    int     // wheelspin intervals within the window
+	WheelspinTime float64 // most wheelspin on any one wheel within the window
+	To100         float64 // 0-100 km/h
+	To200         float64 // 0-200 km/h
+	StartPos      int
+	EndPos        int // race position window seconds after the signal
+	Gained        int // places gained (negative when lost)
+	Window        float64
+}
+
+// DetectLaunch analyses the first race start in the session: reaction, launch RPM,
+// wheelspin from slips (see DetectWheelSlip), 0-100 and 0-200 km/h times and the
+// places gained or lost within window seconds. The speed marks are only taken until
+// the launch ends: the first lift or brake once rolling, or launchMaxTime. ok is false without a start signal.
+// IsRaceOn also drops during pauses and menus, so only a signal with the car standing
+// counts as a start.
+func DetectLaunch(samples []models.Sample, slips []WheelSlipInterval, window float64) (Launch, bool) {
+	start := -1
+	for i, s := range samples {
+		if s.RaceStart && speedMPS(s) < launchMoving {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return Launch{}, false
+	}
+	t0 := samples[start].Time
+	l := Launch{Index: start, Time: t0, StartPos: samples[start].RacePosition, Window: window}
+	for _, s := range samples {
+		if s.HasInputAccel {
+			l.Throttle = true
+			break
+		}
+	}
+	l.Held = l.Throttle && throttleOf(samples[start]) >= launchThrottle
+	reacted, moved, launching := l.Held || !l.Throttle, false, true
+	for _, s := range samples[start:] {
+		t := s.Time - t0
+		if !reacted && throttleOf(s) >= launchThrottle {
+			l.Reaction, reacted = t, true
+		}
+		v := speedMPS(s)
+		if !moved && v >= launchMoving {
+			l.MoveTime, l.LaunchRPM, moved = t, s.EngineCurrentRPM, true
+		}
+		braking := s.HasInputBrake && float64(s.Brake)/255 >= launchBrake
+		lifted := l.Throttle && throttleOf(s) < launchLift
+		if t > launchMaxTime || (moved && (braking || lifted)) {
+			launching = false
+		}
+		if launching && l.To100 == 0 && v >= kmh100 {
+			l.To100 = t
+		}
+		if launching && l.To200 == 0 && v >= kmh200 {
+			l.To200 = t
+		}
+		if t <= window && s.RacePosition > 0 {
+			l.EndPos = s.RacePosition
+		}
+		if t > window && (!launching || l.To200 > 0) {
+			break
+		}
+	}
+	if l.StartPos > 0 && l.EndPos > 0 {
+		l.Gained = l.StartPos - l.EndPos
+	}
+	spin := make(map[string]float64)
+	for _, iv := range slips {
+		if iv.Type != "wheelspin" {
+			continue
+		}
+		if t := samples[iv.Start].Time - t0; t < 0 || t > window {
+			continue
+		}
+		l.Wheelspins++
+		spin[iv.Wheel] += samples[iv.End].Time - samples[iv.Start].Time
+	}
+	for _, d := range spin {
+		l.WheelspinTime = math.Max(l.WheelspinTime, d)
+	}
+	return l, true
+}
+
+// LaunchEvents emits a "race_start" event at the start signal summarising the launch,
+// and "launch_wheelspin" when the launch spun the tyres for launchSpinReported or more.
+func LaunchEvents(samples []models.Sample, l Launch) []models.Event {
+	var parts []string
+	switch {
+	case l.Held:
+		parts = append(parts, "throttle held at the signal")
+	case l.Throttle && l.Reaction > 0:
+		parts = append(parts, fmt.Sprintf("reaction %.2fs", l.Reaction))
+	}
+	if l.To100 > 0 {
+		parts = append(parts, fmt.Sprintf("0-100 km/h %.2fs", l.To100))
+	}
+	if l.StartPos > 0 && l.EndPos > 0 {
+		parts = append(parts, fmt.Sprintf("P%d → P%d after %.0fs", l.StartPos, l.EndPos, l.Window))
+	}
+	note := strings.Join(parts, ", ")
+	if note == "" {
+		note = "start signal"
+	}
+	events := []models.Event{{
+		Index: l.Index,
+		Time:  l.Time,
+		Type:  "race_start",
+		Note:  note,
+	}}
+	if l.WheelspinTime >= launchSpinReported {
+		at := l.Index
+		if l.MoveTime > 0 {
+			for at < len(samples)-1 && samples[at].Time-l.Time < l.MoveTime {
+				at++
+			}
+		}
+		events = append(events, models.Event{
+			Index:    at,
+			Time:     samples[at].Time,
+			Type:     "launch_wheelspin",
+			Note:     fmt.Sprintf("wheelspin %.1fs off the line", l.WheelspinTime),
+			Duration: l.WheelspinTime,
+		})
+	}
+	return events
+}
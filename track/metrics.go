@@ -8,6 +8,7 @@ import (
 // LapMetrics holds timing for a lap and its sectors.
 type LapMetrics struct {
 	Lap         int       `json:"lap"`
+	Type        string    `json:"type,omitempty"` // out, flying, in or partial
 	LapTime     float64   `json:"lapTime"`
 	SectorTime  []float64 `json:"sectorTime,omitempty"`
 	SectorDelta []float64 `json:"sectorDelta,omitempty"`
@@ -24,6 +25,7 @@ func ComputeLapMetrics(samples []models.Sample, points []models.Trackpoint, lapI
 	}
 
 	var out []LapMetrics
+	lapTypes := ClassifyLaps(samples, points, lapIdx)
 
 	for lapNum := 1; lapNum < len(lapIdx); lapNum++ {
 		start := lapIdx[lapNum-1]
@@ -35,6 +37,9 @@ func ComputeLapMetrics(samples []models.Sample, points []models.Trackpoint, lapI
 		// Lap time
 		lt := samples[end-1].Time - samples[start].Time
 		lm := LapMetrics{Lap: lapNum, LapTime: lt}
+		if lapNum-1 < len(lapTypes) {
+			lm.Type = lapTypes[lapNum-1]
+		}
 
 		// Sector times (distance-based, equal slices of lap distance)
 		if sectors > 0 {
@@ -73,34 +78,48 @@ func ComputeLapMetrics(samples []models.Sample, points []models.Trackpoint, lapI
 		out = append(out, lm)
 	}
 
-	if sectors > 0 && len(out) > 0 {
-		best := make([]float64, sectors)
-		for i := range best {
-			best[i] = math.Inf(1)
+	if sectors > 0 {
+		FillSectorDeltas(out)
+	}
+
+	return out
+}
+
+// FillSectorDeltas sets each lap's SectorDelta against the best time per sector.
+func FillSectorDeltas(laps []LapMetrics) {
+	sectors := 0
+	for _, lm := range laps {
+		if len(lm.SectorTime) > sectors {
+			sectors = len(lm.SectorTime)
 		}
-		for _, lm := range out {
-			for i, t := range lm.SectorTime {
-				if t > 0 && t < best[i] {
-					best[i] = t
-				}
+	}
+	if sectors == 0 {
+		return
+	}
+	best := make([]float64, sectors)
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+	for _, lm := range laps {
+		for i, t := range lm.SectorTime {
+			if t > 0 && t < best[i] {
+				best[i] = t
 			}
 		}
-		for i := range out {
-			if len(out[i].SectorTime) == 0 {
+	}
+	for i := range laps {
+		if len(laps[i].SectorTime) == 0 {
+			continue
+		}
+		laps[i].SectorDelta = make([]float64, len(laps[i].SectorTime))
+		for j, t := range laps[i].SectorTime {
+			if best[j] == math.Inf(1) || t == 0 {
+				laps[i].SectorDelta[j] = 0
 				continue
 			}
-			out[i].SectorDelta = make([]float64, len(out[i].SectorTime))
-			for j, t := range out[i].SectorTime {
-				if best[j] == math.Inf(1) || t == 0 {
-					out[i].SectorDelta[j] = 0
-					continue
-				}
-				out[i].SectorDelta[j] = t - best[j]
-			}
+			laps[i].SectorDelta[j] = t - best[j]
 		}
 	}
-
-	return out
 }
 
 // ComputeSteeringAngles estimates steering angle (deg) per point using signed curvature and a given wheelbase.
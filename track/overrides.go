@@ -0,0 +1,156 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"sort"
+)
+
+// LapExcluded marks a lap dropped by a manual override.
+const LapExcluded = "excluded"
+
+// LapMark pins a position in a session either by time (seconds from the first
+// sample) or by distance (meters along the session track). Time wins when both are set.
+type LapMark struct {
+	Time     *float64 `json:"time,omitempty"`
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// LapOverrides holds manual corrections to automatic lap detection for one session.
+// Lap numbers in Merge and Exclude refer to the automatically detected laps (1-based).
+type LapOverrides struct {
+	Source     string    `json:"source,omitempty"`     // file the overrides were read from
+	TrimStart  *LapMark  `json:"trimStart,omitempty"`  // drop everything before this mark
+	TrimEnd    *LapMark  `json:"trimEnd,omitempty"`    // drop everything after this mark
+	Boundaries []LapMark `json:"boundaries,omitempty"` // replace detected lap starts
+	Split      []LapMark `json:"split,omitempty"`      // add lap starts
+	Merge      []int     `json:"merge,omitempty"`      // join lap N with lap N+1
+	Exclude    []int     `json:"exclude,omitempty"`    // keep out of master/stats
+}
+
+func (m *LapMark) String() string {
+	switch {
+	case m == nil:
+		return "unset"
+	case m.Time != nil:
+		return fmt.Sprintf("time %gs", *m.Time)
+	case m.Distance != nil:
+		return fmt.Sprintf("distance %gm", *m.Distance)
+	}
+	return "unset"
+}
+
+// index resolves the mark to the first sample at or after it, or -1 when unset.
+func (m LapMark) index(samples []models.Sample, points []models.Trackpoint) int {
+	n := len(points)
+	if len(samples) < n {
+		n = len(samples)
+	}
+	if n == 0 {
+		return -1
+	}
+	switch {
+	case m.Time != nil:
+		t0 := samples[0].Time
+		for i := 0; i < n; i++ {
+			if samples[i].Time-t0 >= *m.Time {
+				return i
+			}
+		}
+		return n
+	case m.Distance != nil:
+		for i := 0; i < n; i++ {
+			if points[i].S-points[0].S >= *m.Distance {
+				return i
+			}
+		}
+		return n
+	}
+	return -1
+}
+
+// ApplyLapOverrides rewrites lap boundaries using ov and reports which resulting laps
+// are excluded. Steps run in order: pinned boundaries replace detection, merges remove
+// boundaries, splits add them, then the trim window clamps the first and last boundary.
+// A resulting lap is excluded when its midpoint falls inside an excluded detected lap.
+// Merge numbers refer to detected laps, which pinned boundaries replace, so the two
+// cannot be combined. It also fails when the trim window leaves nothing of the session.
+func ApplyLapOverrides(samples []models.Sample, points []models.Trackpoint, lapIdx []int, ov *LapOverrides) ([]int, []bool, error) {
+	if ov == nil || len(lapIdx) < 2 || len(points) == 0 {
+		return lapIdx, make([]bool, max(len(lapIdx)-1, 0)), nil
+	}
+	if len(ov.Boundaries) > 0 && len(ov.Merge) > 0 {
+		return nil, nil, fmt.Errorf("%s: merge cannot be combined with boundaries; leave the merged lap start out of boundaries instead", ov.Source)
+	}
+	end := len(points)
+
+	type span struct{ start, end int }
+	var excludedSpans []span
+	for _, lap := range ov.Exclude {
+		if lap >= 1 && lap < len(lapIdx) {
+			excludedSpans = append(excludedSpans, span{lapIdx[lap-1], lapIdx[lap]})
+		}
+	}
+	removed := make(map[int]bool)
+	for _, lap := range ov.Merge {
+		if lap >= 1 && lap < len(lapIdx)-1 {
+			removed[lapIdx[lap]] = true
+		}
+	}
+
+	bounds := make(map[int]bool)
+	if len(ov.Boundaries) > 0 {
+		for _, m := range ov.Boundaries {
+			if i := m.index(samples, points); i >= 0 && i < end {
+				bounds[i] = true
+			}
+		}
+	} else {
+		for _, i := range lapIdx[:len(lapIdx)-1] {
+			if !removed[i] {
+				bounds[i] = true
+			}
+		}
+	}
+	for _, m := range ov.Split {
+		if i := m.index(samples, points); i > 0 && i < end {
+			bounds[i] = true
+		}
+	}
+
+	lo, hi := 0, end
+	if ov.TrimStart != nil {
+		if i := ov.TrimStart.index(samples, points); i > lo {
+			lo = i
+		}
+	}
+	if ov.TrimEnd != nil {
+		if i := ov.TrimEnd.index(samples, points); i >= 0 && i < hi {
+			hi = i
+		}
+	}
+	if hi <= lo+1 {
+		return nil, nil, fmt.Errorf("%s: trimStart (%s) and trimEnd (%s) leave no samples", ov.Source, ov.TrimStart, ov.TrimEnd)
+	}
+
+	out := []int{lo}
+	for i := range bounds {
+		if i > lo && i < hi {
+			out = append(out, i)
+		}
+	}
+	sort.Ints(out)
+	out = append(out, hi)
+
+	excluded := make([]bool, len(out)-1)
+	for k := 0; k < len(out)-1; k++ {
+		mid := (out[k] + out[k+1]) / 2
+		for _, sp := range excludedSpans {
+			if mid >= sp.start && mid < sp.end {
+				excluded[k] = true
+				break
+			}
+		}
+	}
+	return out, excluded, nil
+}
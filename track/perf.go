@@ -0,0 +1,188 @@
+package track
+
+import (
+	"forza/models"
+	"math"
+)
+
+// Acceleration run thresholds and standard test points. Speeds are m/s, distances m.
+const (
+	perfStill   = 0.5 // below this the car is standing
+	perfMinTop  = mph60
+	perfBrakeOn = 0.1 // brake (0..1) that ends a run and counts for the 100-0 stop
+	perfLift    = 0.5 // throttle (0..1) below this once rolling ends a run
+	perfFade    = 2.0 // without pedal channels, a run ends this far below its peak speed
+	mph60       = 26.8224
+	mph130      = 58.1152
+	quarterMile = 402.336
+	halfMile    = 804.672
+)
+
+// PerfRun is one standing-start acceleration run. Times are seconds from the car
+// starting to roll and zero when the mark was not reached; trap speeds are the speed
+// crossing the distance mark.
+type PerfRun struct {
+	Start      int     // sample index where the car starts rolling
+	End        int     // last sample before the driver lifts or brakes
+	Time       float64 // session time of the start
+	CarOrdinal int
+	PI         int
+	To60MPH    float64
+	To100KMH   float64
+	To200KMH   float64
+	MPH60to130 float64
+	Quarter    float64
+	QuarterMPS float64
+	Half       float64
+	HalfMPS    float64
+	TopSpeed   float64
+	Brake100   float64 // meters from 100 km/h to standstill when the run ends braking to a stop
+}
+
+// FindPerfRuns finds standing-start runs: the car starts rolling from a standstill
+// and reaches at least 60 mph before the driver lifts or brakes (or, without pedal
+// channels, the speed falls off its peak). Distance is integrated from speed so runs
+// work without the Distance channel.
+func FindPerfRuns(samples []models.Sample) []PerfRun {
+	var runs []PerfRun
+	for i := 1; i < len(samples); i++ {
+		if speedMPS(samples[i-1]) >= perfStill || speedMPS(samples[i]) < perfStill {
+			continue
+		}
+		end := accelEnd(samples, i)
+		if r, ok := measureRun(samples, i-1, end); ok {
+			r.Brake100 = stopDistance(samples, end)
+			runs = append(runs, r)
+		}
+		i = end
+	}
+	return runs
+}
+
+// accelEnd returns the last sample of the acceleration that starts rolling at
+// samples[from]: the sample before a lift, a brake, the car stopping, or without pedal
+// channels its speed falling perfFade below the peak.
+func accelEnd(samples []models.Sample, from int) int {
+	var peak float64
+	for k := from; k < len(samples); k++ {
+		s := samples[k]
+		v := speedMPS(s)
+		peak = math.Max(peak, v)
+		braking := s.HasInputBrake && float64(s.Brake)/255 >= perfBrakeOn
+		lifted := s.HasInputAccel && throttleOf(s) < perfLift
+		faded := !s.HasInputAccel && !s.HasInputBrake && v < peak-perfFade
+		if v < perfStill || braking || lifted || faded {
+			return max(k-1, from)
+		}
+	}
+	return len(samples) - 1
+}
+
+// measureRun times the standard marks between samples start (still) and end.
+func measureRun(samples []models.Sample, start, end int) (PerfRun, bool) {
+	t0 := samples[start].Time
+	r := PerfRun{Start: start, End: end, Time: t0}
+	// crossing interpolates the time a rising value passes mark between samples.
+	crossing := func(prev, cur, mark, tPrev, tCur float64) float64 {
+		if cur == prev {
+			return tCur - t0
+		}
+		return tPrev + (mark-prev)/(cur-prev)*(tCur-tPrev) - t0
+	}
+	var dist float64
+	for k := start + 1; k <= end; k++ {
+		p, s := samples[k-1], samples[k]
+		if r.CarOrdinal == 0 && s.CarOrdinal != 0 {
+			r.CarOrdinal, r.PI = s.CarOrdinal, s.CarPerformanceIndex
+		}
+		v0, v1 := speedMPS(p), speedMPS(s)
+		dt := s.Time - p.Time
+		if dt <= 0 || dt > 1 {
+			continue
+		}
+		d0 := dist
+		dist += (v0 + v1) / 2 * dt
+		r.TopSpeed = math.Max(r.TopSpeed, v1)
+		up := func(mark float64, field *float64) {
+			if *field == 0 && v0 < mark && v1 >= mark {
+				*field = crossing(v0, v1, mark, p.Time, s.Time)
+			}
+		}
+		up(mph60, &r.To60MPH)
+		up(kmh100, &r.To100KMH)
+		up(kmh200, &r.To200KMH)
+		var to130 float64
+		up(mph130, &to130)
+		if to130 > 0 && r.MPH60to130 == 0 && r.To60MPH > 0 {
+			r.MPH60to130 = to130 - r.To60MPH
+		}
+		// trap interpolates the speed at a distance mark crossed in this interval.
+		trap := func(mark float64) float64 {
+			return v0 + (mark-d0)/(dist-d0)*(v1-v0)
+		}
+		if r.Quarter == 0 && d0 < quarterMile && dist >= quarterMile {
+			r.Quarter = crossing(d0, dist, quarterMile, p.Time, s.Time)
+			r.QuarterMPS = trap(quarterMile)
+		}
+		if r.Half == 0 && d0 < halfMile && dist >= halfMile {
+			r.Half = crossing(d0, dist, halfMile, p.Time, s.Time)
+			r.HalfMPS = trap(halfMile)
+		}
+	}
+	if r.TopSpeed < perfMinTop {
+		return PerfRun{}, false
+	}
+	return r, true
+}
+
+// stopDistance measures the distance from the drop through 100 km/h to the
+// standstill after a run that ended at sample run, when the driver braked straight to
+// that stop without getting back on the throttle. Zero otherwise.
+func stopDistance(samples []models.Sample, run int) float64 {
+	end := -1
+	for k := run + 1; k < len(samples); k++ {
+		s := samples[k]
+		if s.HasInputAccel && throttleOf(s) >= perfLift {
+			return 0
+		}
+		if speedMPS(s) < perfStill {
+			end = k
+			break
+		}
+	}
+	if end < 0 {
+		return 0
+	}
+	from := -1
+	for k := end; k > run; k-- {
+		if speedMPS(samples[k-1]) >= kmh100 && speedMPS(samples[k]) < kmh100 {
+			from = k - 1
+			break
+		}
+	}
+	if from < 0 {
+		return 0
+	}
+	braked := false
+	var dist float64
+	for k := from + 1; k <= end; k++ {
+		p, s := samples[k-1], samples[k]
+		if s.HasInputBrake && float64(s.Brake)/255 >= perfBrakeOn {
+			braked = true
+		}
+		if s.Time <= p.Time || s.Time-p.Time > 1 {
+			continue
+		}
+		v0, v1, t0 := speedMPS(p), speedMPS(s), p.Time
+		if k == from+1 {
+			// Start the integral at the 100 km/h crossing itself.
+			t0 += (v0 - kmh100) / (v0 - v1) * (s.Time - p.Time)
+			v0 = kmh100
+		}
+		dist += (v0 + v1) / 2 * (s.Time - t0)
+	}
+	if !braked {
+		return 0
+	}
+	return dist
+}
@@ -0,0 +1,258 @@
+package track
+
+import "math"
+
+// PhaseSample is one point of a lap mapped onto the master, with the driver inputs
+// needed to split a corner into phases. Throttle and Brake are 0..1; Steer is the raw
+// steering input (0 when not recorded), YawRate is rad/s.
+type PhaseSample struct {
+	RelS     float64
+	Time     float64
+	Speed    float64
+	Throttle float64
+	Brake    float64
+	Steer    float64
+	YawRate  float64
+	Pedals   bool // Throttle and Brake are pedal inputs, not estimated from acceleration
+}
+
+// CornerPhases locates the phases of one pass through a corner. Positions are master
+// relS (0 = phase not found); times are seconds spent in each phase.
+type CornerPhases struct {
+	BrakeOnsetS   float64 `json:"brakeOnsetS,omitempty"`
+	BrakePeakS    float64 `json:"brakePeakS,omitempty"`
+	BrakeReleaseS float64 `json:"brakeReleaseS,omitempty"`
+	PeakBrake     float64 `json:"peakBrake,omitempty"` // 0..1
+	TurnInS       float64 `json:"turnInS,omitempty"`
+	MinSpeedS     float64 `json:"minSpeedS,omitempty"`  // actual (minimum-speed) apex
+	ApexOffset    float64 `json:"apexOffset,omitempty"` // MinSpeedS - geometric ApexS (m)
+	ThrottleS     float64 `json:"throttleS,omitempty"`
+	FullThrottleS float64 `json:"fullThrottleS,omitempty"`
+	BrakeTime     float64 `json:"brakeTime,omitempty"`  // onset -> release
+	EntryTime     float64 `json:"entryTime,omitempty"`  // turn-in -> minimum speed
+	MidTime       float64 `json:"midTime,omitempty"`    // minimum speed -> throttle pickup
+	ExitTime      float64 `json:"exitTime,omitempty"`   // pickup -> full throttle
+	CornerTime    float64 `json:"cornerTime,omitempty"` // corner StartS -> EndS
+}
+
+// PhaseDelta compares a pass through a corner with the best pass. Distances are meters
+// along the master (positive = later than best); times are seconds (positive = longer).
+type PhaseDelta struct {
+	Lap           int     `json:"lap"` // best lap compared against
+	BrakeOnsetM   float64 `json:"brakeOnsetM,omitempty"`
+	TurnInM       float64 `json:"turnInM,omitempty"`
+	MinSpeedM     float64 `json:"minSpeedM,omitempty"`
+	ThrottleM     float64 `json:"throttleM,omitempty"`
+	FullThrottleM float64 `json:"fullThrottleM,omitempty"`
+	BrakeTime     float64 `json:"brakeTime,omitempty"`
+	EntryTime     float64 `json:"entryTime,omitempty"`
+	MidTime       float64 `json:"midTime,omitempty"`
+	ExitTime      float64 `json:"exitTime,omitempty"`
+	CornerTime    float64 `json:"cornerTime,omitempty"`
+}
+
+// Phase thresholds on the 0..1 pedal scale.
+const (
+	phaseBrakeOn     = 0.15 // a braking zone must reach this pressure
+	phaseBrakeOff    = 0.05 // pressure below this counts as released
+	phaseBrakeMin    = 0.25 // seconds; shorter zones are dabs, not braking for the corner
+	phaseThrottleOn  = 0.2
+	phaseThrottleMax = 0.95
+	phaseTurnIn      = 0.3   // fraction of the corner's peak steering (or yaw rate)
+	phaseApproach    = 250.0 // meters before the corner searched for braking
+	phaseRunout      = 250.0 // meters after the corner searched for full throttle
+)
+
+// CornerWindow returns the stretch of master relS searched for corner i's phases:
+// from the previous corner's exit (at most phaseApproach before the corner) to the
+// next corner's entry (at most phaseRunout after it).
+func CornerWindow(corners []CornerDef, i int) (float64, float64) {
+	c := corners[i]
+	lo := c.StartS - phaseApproach
+	if i > 0 {
+		lo = math.Max(lo, corners[i-1].EndS)
+	}
+	hi := c.EndS + phaseRunout
+	if i+1 < len(corners) {
+		hi = math.Min(hi, corners[i+1].StartS)
+	}
+	return math.Max(0, lo), math.Max(hi, c.EndS)
+}
+
+// AnalyzeCornerPhases splits one lap's pass through corners[i] into braking, turn-in,
+// minimum-speed apex and throttle phases. lap must be ordered by RelS. ok is false
+// when the lap has no points inside the corner.
+func AnalyzeCornerPhases(lap []PhaseSample, corners []CornerDef, i int) (CornerPhases, bool) {
+	var ph CornerPhases
+	c := corners[i]
+	first, last, minIdx, ok := cornerSpan(lap, corners, i)
+	if !ok {
+		return ph, false
+	}
+	ph.MinSpeedS = lap[minIdx].RelS
+	ph.ApexOffset = ph.MinSpeedS - c.ApexS
+	ph.CornerTime = timeAcross(lap[first:last+1], c.StartS, c.EndS)
+
+	if on, peak, off, ok := brakeZone(lap, first, minIdx, last); ok {
+		ph.BrakeOnsetS = lap[on].RelS
+		ph.BrakePeakS = lap[peak].RelS
+		ph.BrakeReleaseS = lap[off].RelS
+		ph.PeakBrake = lap[peak].Brake
+		ph.BrakeTime = lap[off].Time - lap[on].Time
+	}
+	if turn, ok := turnIn(lap, c, first, last, minIdx); ok {
+		ph.TurnInS = lap[turn].RelS
+		ph.EntryTime = lap[minIdx].Time - lap[turn].Time
+	}
+
+	// Throttle pickup and full throttle after the minimum-speed point.
+	pick := -1
+	for k := minIdx; k <= last; k++ {
+		if lap[k].Throttle >= phaseThrottleOn && lap[k].Brake < phaseBrakeOff {
+			pick = k
+			break
+		}
+	}
+	if pick >= 0 {
+		ph.ThrottleS = lap[pick].RelS
+		ph.MidTime = lap[pick].Time - lap[minIdx].Time
+		for k := pick; k <= last; k++ {
+			if lap[k].Throttle >= phaseThrottleMax {
+				ph.FullThrottleS = lap[k].RelS
+				ph.ExitTime = lap[k].Time - lap[pick].Time
+				break
+			}
+		}
+	}
+	return ph, true
+}
+
+// cornerSpan returns the first and last lap indices inside corner i's window and the
+// minimum-speed index within the corner itself; ok is false when the lap misses it.
+func cornerSpan(lap []PhaseSample, corners []CornerDef, i int) (int, int, int, bool) {
+	c := corners[i]
+	winLo, winHi := CornerWindow(corners, i)
+	first, last, minIdx := -1, -1, -1
+	for k, p := range lap {
+		if p.RelS < winLo || p.RelS > winHi {
+			continue
+		}
+		if first < 0 {
+			first = k
+		}
+		last = k
+		if p.RelS >= c.StartS && p.RelS <= c.EndS && (minIdx < 0 || p.Speed < lap[minIdx].Speed) {
+			minIdx = k
+		}
+	}
+	return first, last, minIdx, minIdx >= 0
+}
+
+// brakeZone picks the braking zone that does the most braking (pressure x time)
+// between first and minIdx and returns its onset, peak and release indices. Dabs
+// shorter than phaseBrakeMin never count; a zone already running at the lap's first sample has
+// no known onset and is ignored.
+func brakeZone(lap []PhaseSample, first, minIdx, last int) (int, int, int, bool) {
+	bestOn, bestPeak, bestOff := -1, -1, -1
+	bestWork := 0.0
+	for k := first; k <= minIdx; k++ {
+		if lap[k].Brake <= phaseBrakeOff {
+			continue
+		}
+		on, peak, off := k, k, k
+		for off < last && lap[off+1].Brake > phaseBrakeOff {
+			off++
+			if lap[off].Brake > lap[peak].Brake {
+				peak = off
+			}
+		}
+		var work float64
+		for j := on + 1; j <= off; j++ {
+			work += lap[j].Brake * (lap[j].Time - lap[j-1].Time)
+		}
+		long := lap[off].Time-lap[on].Time >= phaseBrakeMin
+		if lap[peak].Brake >= phaseBrakeOn && long && on > 0 && (bestOn < 0 || work > bestWork) {
+			bestOn, bestPeak, bestOff, bestWork = on, peak, off, work
+		}
+		k = off
+	}
+	if bestOn < 0 {
+		return 0, 0, 0, false
+	}
+	for bestOn > first && lap[bestOn-1].Brake > phaseBrakeOff {
+		bestOn--
+	}
+	return bestOn, bestPeak, bestOff, true
+}
+
+// turnIn finds the start of the steering (or, without steering data, yaw) build-up
+// that carries into the minimum-speed point.
+func turnIn(lap []PhaseSample, c CornerDef, first, last, minIdx int) (int, bool) {
+	signal := func(p PhaseSample) float64 { return math.Abs(p.YawRate) }
+	for k := first; k <= last; k++ {
+		if lap[k].Steer != 0 {
+			signal = func(p PhaseSample) float64 { return math.Abs(p.Steer) }
+			break
+		}
+	}
+	peakSig := 0.0
+	for k := first; k <= last; k++ {
+		if lap[k].RelS >= c.StartS && lap[k].RelS <= c.EndS {
+			peakSig = math.Max(peakSig, signal(lap[k]))
+		}
+	}
+	if peakSig <= 0 {
+		return 0, false
+	}
+	turn := minIdx
+	for turn > first && signal(lap[turn-1]) >= phaseTurnIn*peakSig {
+		turn--
+	}
+	return turn, signal(lap[turn]) >= phaseTurnIn*peakSig
+}
+
+// ComparePhases reports how a pass differs from the best pass through the same corner.
+// Phases missing from either side are left at zero.
+func ComparePhases(p, best CornerPhases, bestLap int) PhaseDelta {
+	diff := func(a, b float64) float64 {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return a - b
+	}
+	return PhaseDelta{
+		Lap:           bestLap,
+		BrakeOnsetM:   diff(p.BrakeOnsetS, best.BrakeOnsetS),
+		TurnInM:       diff(p.TurnInS, best.TurnInS),
+		MinSpeedM:     diff(p.MinSpeedS, best.MinSpeedS),
+		ThrottleM:     diff(p.ThrottleS, best.ThrottleS),
+		FullThrottleM: diff(p.FullThrottleS, best.FullThrottleS),
+		BrakeTime:     diff(p.BrakeTime, best.BrakeTime),
+		EntryTime:     diff(p.EntryTime, best.EntryTime),
+		MidTime:       diff(p.MidTime, best.MidTime),
+		ExitTime:      diff(p.ExitTime, best.ExitTime),
+		CornerTime:    diff(p.CornerTime, best.CornerTime),
+	}
+}
+
+// timeAcross interpolates the time taken between master distances from and to.
+func timeAcross(lap []PhaseSample, from, to float64) float64 {
+	at := func(s float64) (float64, bool) {
+		for k := 1; k < len(lap); k++ {
+			a, b := lap[k-1], lap[k]
+			if a.RelS <= s && b.RelS >= s {
+				if b.RelS == a.RelS {
+					return a.Time, true
+				}
+				return a.Time + (b.Time-a.Time)*(s-a.RelS)/(b.RelS-a.RelS), true
+			}
+		}
+		return 0, false
+	}
+	t0, ok0 := at(from)
+	t1, ok1 := at(to)
+	if !ok0 || !ok1 {
+		return 0
+	}
+	return t1 - t0
+}
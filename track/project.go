@@ -0,0 +1,212 @@
+package track
+
+import (
+	"forza/models"
+	"math"
+)
+
+// Projection is a point matched geometrically onto the master track.
+type Projection struct {
+	Index      int     // master index closest to the projected point
+	RelS       float64 // master distance at the projected point
+	X          float64 // projected point on the master track
+	Y          float64
+	Lateral    float64 // signed offset (m), positive = left of the master tangent
+	HeadingErr float64 // point heading minus master heading (rad, wrapped)
+	DistanceSq float64 // squared distance from the point to the projection
+}
+
+// MasterIndex is a uniform grid over the master track segments so projections only
+// look at nearby segments instead of scanning the whole lap.
+type MasterIndex struct {
+	master []models.Trackpoint
+	cell   float64
+	grid   map[[2]int][]int // cell -> segment start indices
+}
+
+// NewMasterIndex buckets every master segment into the grid cells its bounding box
+// touches. cellSize defaults to 25 m when <= 0.
+func NewMasterIndex(master []models.Trackpoint, cellSize float64) *MasterIndex {
+	if cellSize <= 0 {
+		cellSize = 25
+	}
+	idx := &MasterIndex{master: master, cell: cellSize, grid: make(map[[2]int][]int)}
+	for i := 0; i+1 < len(master); i++ {
+		a, b := master[i], master[i+1]
+		x0, y0 := idx.key(math.Min(a.X, b.X), math.Min(a.Y, b.Y))
+		x1, y1 := idx.key(math.Max(a.X, b.X), math.Max(a.Y, b.Y))
+		for cx := x0; cx <= x1; cx++ {
+			for cy := y0; cy <= y1; cy++ {
+				k := [2]int{cx, cy}
+				idx.grid[k] = append(idx.grid[k], i)
+			}
+		}
+	}
+	return idx
+}
+
+func (m *MasterIndex) key(x, y float64) (int, int) {
+	return int(math.Floor(x / m.cell)), int(math.Floor(y / m.cell))
+}
+
+// Project finds the closest master segment to (x, y) whose distance lies within
+// [minS, maxS]. It widens the grid search ring by ring and falls back to scanning
+// the S window when nothing nearby qualifies. ok is false for an empty master.
+func (m *MasterIndex) Project(x, y, theta, minS, maxS float64) (Projection, bool) {
+	if len(m.master) < 2 {
+		return Projection{}, false
+	}
+	const maxRings = 8
+	cx, cy := m.key(x, y)
+	bestSeg, bestT, bestD := -1, 0.0, math.Inf(1)
+	consider := func(i int) {
+		a, b := m.master[i], m.master[i+1]
+		t, d := projectOnSegment(a, b, x, y)
+		s := a.S + t*(b.S-a.S)
+		if s < minS || s > maxS {
+			return
+		}
+		if d < bestD {
+			bestSeg, bestT, bestD = i, t, d
+		}
+	}
+	for r := 0; r <= maxRings && bestSeg < 0; r++ {
+		for dx := -r; dx <= r; dx++ {
+			for dy := -r; dy <= r; dy++ {
+				if max(abs(dx), abs(dy)) != r {
+					continue
+				}
+				for _, i := range m.grid[[2]int{cx + dx, cy + dy}] {
+					consider(i)
+				}
+			}
+		}
+		// A segment one ring further out can still be closer than a corner hit.
+		if bestSeg >= 0 && r < maxRings {
+			for dx := -(r + 1); dx <= r+1; dx++ {
+				for dy := -(r + 1); dy <= r+1; dy++ {
+					if max(abs(dx), abs(dy)) != r+1 {
+						continue
+					}
+					for _, i := range m.grid[[2]int{cx + dx, cy + dy}] {
+						consider(i)
+					}
+				}
+			}
+		}
+	}
+	if bestSeg < 0 {
+		for i := 0; i+1 < len(m.master); i++ {
+			if m.master[i+1].S >= minS && m.master[i].S <= maxS {
+				consider(i)
+			}
+		}
+	}
+	if bestSeg < 0 {
+		return Projection{}, false
+	}
+	return m.projection(bestSeg, bestT, x, y, theta), true
+}
+
+func (m *MasterIndex) projection(seg int, t, x, y, theta float64) Projection {
+	a, b := m.master[seg], m.master[seg+1]
+	px := a.X + t*(b.X-a.X)
+	py := a.Y + t*(b.Y-a.Y)
+	segX, segY := b.X-a.X, b.Y-a.Y
+	dx, dy := x-px, y-py
+	lateral := math.Hypot(dx, dy)
+	if segX*dy-segY*dx < 0 {
+		lateral = -lateral
+	}
+	closest := seg
+	if t > 0.5 {
+		closest = seg + 1
+	}
+	heading := a.Theta + t*wrapAngle(b.Theta-a.Theta)
+	return Projection{
+		Index:      closest,
+		RelS:       a.S + t*(b.S-a.S),
+		X:          px,
+		Y:          py,
+		Lateral:    lateral,
+		HeadingErr: wrapAngle(theta - heading),
+		DistanceSq: dx*dx + dy*dy,
+	}
+}
+
+// projectOnSegment returns the clamped parameter t of the closest point on a→b and
+// the squared distance to it.
+func projectOnSegment(a, b models.Trackpoint, x, y float64) (float64, float64) {
+	segX, segY := b.X-a.X, b.Y-a.Y
+	len2 := segX*segX + segY*segY
+	t := 0.0
+	if len2 > 0 {
+		t = clamp01(((x-a.X)*segX + (y-a.Y)*segY) / len2)
+	}
+	dx := x - (a.X + t*segX)
+	dy := y - (a.Y + t*segY)
+	return t, dx*dx + dy*dy
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// ProjectToMaster geometrically map-matches a lap onto the master track. Progress is
+// kept monotonic: each point searches a window just behind to well ahead of the
+// previous match, and small backward jitter is clamped to the previous relS.
+// The callback receives the point index within the full session and its projection.
+func ProjectToMaster(lap []models.Trackpoint, idx *MasterIndex, startIndex int, emit func(i int, p Projection)) {
+	if len(lap) == 0 || idx == nil || len(idx.master) < 2 || emit == nil {
+		return
+	}
+	const backTol = 10.0   // meters a match may fall behind the previous one
+	const aheadTol = 150.0 // meters a match may jump ahead
+	masterLen := idx.master[len(idx.master)-1].S
+	prevS := 0.0
+	for i, p := range lap {
+		minS := math.Max(0, prevS-backTol)
+		maxS := prevS + aheadTol
+		if i == 0 {
+			// The lap may start some way from the master start, but not past halfway.
+			minS, maxS = 0, masterLen/2
+		}
+		proj, ok := idx.Project(p.X, p.Y, p.Theta, minS, math.Min(maxS, masterLen))
+		if !ok {
+			continue
+		}
+		if proj.RelS < prevS {
+			proj.RelS = prevS
+		}
+		prevS = proj.RelS
+		emit(startIndex+i, proj)
+	}
+}
+
+// MatchRMS projects every step-th point onto the master without a progress
+// constraint and returns the RMS distance (m). It is a cheap check that another
+// path runs along the same track; +Inf when nothing could be projected.
+func (m *MasterIndex) MatchRMS(points []models.Trackpoint, step int) float64 {
+	if step < 1 {
+		step = 1
+	}
+	if len(m.master) < 2 {
+		return math.Inf(1)
+	}
+	masterLen := m.master[len(m.master)-1].S
+	var sum float64
+	n := 0
+	for i := 0; i < len(points); i += step {
+		if pr, ok := m.Project(points[i].X, points[i].Y, points[i].Theta, 0, masterLen); ok {
+			sum += pr.DistanceSq
+			n++
+		}
+	}
+	if n == 0 {
+		return math.Inf(1)
+	}
+	return math.Sqrt(sum / float64(n))
+}
@@ -0,0 +1,142 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SpeedTrap is a speed measurement point on the master, or a zone (EndS > S) whose
+// reading is the average speed through it, like the game's speed traps and zones.
+type SpeedTrap struct {
+	ID   string  `json:"id"`
+	Name string  `json:"name,omitempty"`
+	S    float64 `json:"relS"`
+	EndS float64 `json:"endS,omitempty"`
+	Auto bool    `json:"auto,omitempty"` // placed at a straight's top speed, not user-defined
+}
+
+// Zone reports whether the trap measures average speed over a stretch.
+func (t SpeedTrap) Zone() bool { return t.EndS > t.S }
+
+const (
+	autoTrapMinStraight = 150.0 // m; shorter straights get no automatic trap
+	autoTrapClearance   = 50.0  // m from a user trap within which no automatic trap is added
+)
+
+// ParseSpeedTraps reads a comma-separated list of trap positions (master relS, e.g.
+// "1200") and zones ("2500-2900").
+func ParseSpeedTraps(spec string) ([]SpeedTrap, error) {
+	var traps []SpeedTrap
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		from, to, zone := strings.Cut(item, "-")
+		s, err := strconv.ParseFloat(strings.TrimSpace(from), 64)
+		if err != nil || s < 0 {
+			return nil, fmt.Errorf("speed trap %q: want relS or start-end", item)
+		}
+		t := SpeedTrap{S: s}
+		if zone {
+			if t.EndS, err = strconv.ParseFloat(strings.TrimSpace(to), 64); err != nil || t.EndS <= s {
+				return nil, fmt.Errorf("speed zone %q: end must be a number after the start", item)
+			}
+		}
+		traps = append(traps, t)
+	}
+	return traps, nil
+}
+
+// AutoSpeedTraps places a trap at the highest average speed (avgSpeed per master
+// point) on each straight of at least autoTrapMinStraight, unless a user trap or zone
+// already covers it.
+func AutoSpeedTraps(master []models.Trackpoint, avgSpeed []float64, segments []SegmentDef, user []SpeedTrap) []SpeedTrap {
+	var traps []SpeedTrap
+	for _, seg := range segments {
+		if seg.Type != "straight" || seg.EndS-seg.StartS < autoTrapMinStraight {
+			continue
+		}
+		best := -1
+		for i, p := range master {
+			if p.S < seg.StartS || p.S > seg.EndS || i >= len(avgSpeed) {
+				continue
+			}
+			if best < 0 || avgSpeed[i] > avgSpeed[best] {
+				best = i
+			}
+		}
+		if best < 0 || avgSpeed[best] <= 0 {
+			continue
+		}
+		s := master[best].S
+		covered := false
+		for _, u := range user {
+			if (u.Zone() && s >= u.S && s <= u.EndS) || (s-u.S < autoTrapClearance && u.S-s < autoTrapClearance) {
+				covered = true
+			}
+		}
+		if !covered {
+			traps = append(traps, SpeedTrap{S: s, Auto: true})
+		}
+	}
+	return traps
+}
+
+// NumberSpeedTraps sorts traps by position and gives those without an ID one:
+// "ST<n>" for traps and "SZ<n>" for zones, numbered after the IDs already in use.
+func NumberSpeedTraps(traps []SpeedTrap) {
+	sort.SliceStable(traps, func(i, j int) bool { return traps[i].S < traps[j].S })
+	used := make(map[string]bool)
+	for _, t := range traps {
+		used[t.ID] = true
+	}
+	next := map[bool]int{false: 1, true: 1}
+	for i := range traps {
+		if traps[i].ID != "" {
+			continue
+		}
+		prefix := "ST"
+		if traps[i].Zone() {
+			prefix = "SZ"
+		}
+		for used[fmt.Sprintf("%s%d", prefix, next[traps[i].Zone()])] {
+			next[traps[i].Zone()]++
+		}
+		traps[i].ID = fmt.Sprintf("%s%d", prefix, next[traps[i].Zone()])
+		used[traps[i].ID] = true
+	}
+}
+
+// TrapSpeed reads a lap's speed at a trap: interpolated at the trap point, or
+// time-averaged over a zone. The result is in the units of PhaseSample.Speed; ok is
+// false when the lap does not cover the trap.
+func TrapSpeed(lap []PhaseSample, t SpeedTrap) (float64, bool) {
+	if !t.Zone() {
+		for k := 0; k+1 < len(lap); k++ {
+			a, b := lap[k], lap[k+1]
+			if a.RelS <= t.S && b.RelS > t.S && b.Time-a.Time <= 1 {
+				f := (t.S - a.RelS) / (b.RelS - a.RelS)
+				return a.Speed + f*(b.Speed-a.Speed), true
+			}
+		}
+		return 0, false
+	}
+	var sum, dur float64
+	for k := 0; k+1 < len(lap); k++ {
+		a, b := lap[k], lap[k+1]
+		dt := b.Time - a.Time
+		if a.RelS < t.S || a.RelS >= t.EndS || dt <= 0 || dt > 1 {
+			continue
+		}
+		sum += (a.Speed + b.Speed) / 2 * dt
+		dur += dt
+	}
+	if dur == 0 || len(lap) == 0 || lap[0].RelS > t.S || lap[len(lap)-1].RelS < t.EndS {
+		return 0, false
+	}
+	return sum / dur, true
+}
@@ -0,0 +1,209 @@
+package track
+
+import "math"
+
+// SteeringStats describes how the steering input was used over one lap or corner.
+// Steering is normalised to -1..1 of lock.
+type SteeringStats struct {
+	Lap              int     `json:"lap,omitempty"`
+	Time             float64 `json:"time"`
+	Reversals        int     `json:"reversals"`
+	ReversalRate     float64 `json:"reversalRate"`          // reversals per minute
+	Corrections      int     `json:"corrections,omitempty"` // small reversals
+	SawingTime       float64 `json:"sawingTime,omitempty"`  // seconds of rapid back-and-forth
+	CounterSteers    int     `json:"counterSteers,omitempty"`
+	CounterSteerTime float64 `json:"counterSteerTime,omitempty"`
+	RateRMS          float64 `json:"rateRMS"`    // steering rate RMS (lock/s)
+	Smoothness       float64 `json:"smoothness"` // 0..100, 100 = no wasted movement
+}
+
+// SteerInterval is a counter-steer moment on a lap (sample indices, inclusive). Type
+// is "snap" when it was caught with a fast steering flick, else "countersteer".
+type SteerInterval struct {
+	Type     string
+	Start    int
+	End      int
+	PeakRate float64 // lock/s
+}
+
+const (
+	steerReversalGap   = 0.02 // lock; swing needed to count a reversal
+	steerCorrectionMax = 0.1  // lock; reversals smaller than this are corrections
+	steerSawWindow     = 1.0  // seconds spanning three reversals to call it sawing
+	steerSmoothWindow  = 0.3  // seconds; moving average for the smoothness reference
+	counterSteerMin    = 0.05 // lock against the rotation
+	counterYawMin      = 0.15 // rad/s of yaw before counter-steer counts
+	counterSpeedMin    = 20.0 // speed (sample units) for counter-steer
+	counterMinTime     = 0.15 // seconds
+	snapRate           = 3.0  // lock/s steering rate that marks a snap catch
+)
+
+func steerNorm(p PhaseSample) float64 { return clamp(p.Steer/127, -1, 1) }
+
+// HasSteering reports whether the lap carries a steering input channel.
+func HasSteering(lap []PhaseSample) bool {
+	for _, p := range lap {
+		if p.Steer != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SteerYawSign returns +1 when positive steering turns the car to positive yaw rate,
+// -1 when the telemetry uses opposite conventions, or 0 when it cannot be told.
+func SteerYawSign(lap []PhaseSample) float64 {
+	var sum float64
+	for _, p := range lap {
+		if d := steerNorm(p); math.Abs(d) >= counterSteerMin && p.Speed >= counterSpeedMin {
+			sum += d * p.YawRate
+		}
+	}
+	switch {
+	case sum > 0:
+		return 1
+	case sum < 0:
+		return -1
+	}
+	return 0
+}
+
+// counterSteering reports whether the steering points against the car's rotation.
+func counterSteering(p PhaseSample, sign float64) bool {
+	d := steerNorm(p)
+	return sign != 0 && p.Speed >= counterSpeedMin && math.Abs(d) >= counterSteerMin &&
+		math.Abs(p.YawRate) >= counterYawMin && d*sign*p.YawRate < 0
+}
+
+// SteeringUse measures steering reversals, corrections, sawing, counter-steer and
+// smoothness between master distances fromS and toS. sign comes from SteerYawSign.
+func SteeringUse(lap []PhaseSample, fromS, toS, sign float64) SteeringStats {
+	var st SteeringStats
+	var seg []PhaseSample
+	for _, p := range lap {
+		if p.RelS >= fromS && p.RelS < toS {
+			seg = append(seg, p)
+		}
+	}
+	if len(seg) < 3 {
+		return st
+	}
+	st.Time = seg[len(seg)-1].Time - seg[0].Time
+
+	// Reversals with hysteresis: the steering must swing back by steerReversalGap
+	// from its last extreme before a change of direction counts.
+	var revTimes []float64
+	dir := 0
+	ext, prevExt := steerNorm(seg[0]), steerNorm(seg[0])
+	for _, p := range seg[1:] {
+		d := steerNorm(p)
+		reversed := false
+		switch {
+		case dir >= 0 && d > ext:
+			ext = d
+			if dir == 0 && ext-prevExt >= steerReversalGap {
+				dir = 1
+			}
+		case dir <= 0 && d < ext:
+			ext = d
+			if dir == 0 && prevExt-ext >= steerReversalGap {
+				dir = -1
+			}
+		case dir == 1 && ext-d >= steerReversalGap:
+			reversed, dir = true, -1
+		case dir == -1 && d-ext >= steerReversalGap:
+			reversed, dir = true, 1
+		}
+		if reversed {
+			st.Reversals++
+			if math.Abs(ext-prevExt) < steerCorrectionMax {
+				st.Corrections++
+			}
+			revTimes = append(revTimes, p.Time)
+			prevExt, ext = ext, d
+		}
+	}
+	if st.Time > 0 {
+		st.ReversalRate = float64(st.Reversals) / st.Time * 60
+	}
+	for j := 2; j < len(revTimes); j++ {
+		if revTimes[j]-revTimes[j-2] <= steerSawWindow {
+			st.SawingTime += revTimes[j] - revTimes[j-1]
+		}
+	}
+
+	// Smoothness: steering travel of a smoothed trace over the raw travel.
+	raw := make([]float64, len(seg))
+	for k, p := range seg {
+		raw[k] = steerNorm(p)
+	}
+	dt := st.Time / float64(len(seg)-1)
+	window := 1
+	if dt > 0 {
+		window = max(1, int(steerSmoothWindow/dt))
+	}
+	smooth := SmoothSeries(raw, window)
+	var rawTravel, smoothTravel, rateSq float64
+	n := 0
+	for k := 1; k < len(seg); k++ {
+		rawTravel += math.Abs(raw[k] - raw[k-1])
+		smoothTravel += math.Abs(smooth[k] - smooth[k-1])
+		if h := seg[k].Time - seg[k-1].Time; h > 0 {
+			r := (raw[k] - raw[k-1]) / h
+			rateSq += r * r
+			n++
+		}
+	}
+	st.Smoothness = 100
+	if rawTravel > 0.05 {
+		st.Smoothness = 100 * math.Min(1, smoothTravel/rawTravel)
+	}
+	if n > 0 {
+		st.RateRMS = math.Sqrt(rateSq / float64(n))
+	}
+
+	for _, iv := range DetectSteerIntervals(seg, sign) {
+		st.CounterSteers++
+		st.CounterSteerTime += seg[iv.End].Time - seg[iv.Start].Time
+	}
+	return st
+}
+
+// DetectSteerIntervals finds counter-steer moments: steering held against the car's
+// rotation at speed for at least counterMinTime.
+func DetectSteerIntervals(lap []PhaseSample, sign float64) []SteerInterval {
+	var out []SteerInterval
+	start := -1
+	peak := 0.0
+	flush := func(end int) {
+		if start >= 0 && lap[end].Time-lap[start].Time >= counterMinTime {
+			typ := "countersteer"
+			if peak >= snapRate {
+				typ = "snap"
+			}
+			out = append(out, SteerInterval{Type: typ, Start: start, End: end, PeakRate: peak})
+		}
+		start, peak = -1, 0
+	}
+	for k, p := range lap {
+		if !counterSteering(p, sign) {
+			if start >= 0 {
+				flush(k - 1)
+			}
+			continue
+		}
+		if start < 0 {
+			start = k
+		}
+		// The flick into the counter-steer (from the previous sample) is part of the catch.
+		if k > 0 {
+			if h := p.Time - lap[k-1].Time; h > 0 {
+				peak = math.Max(peak, math.Abs(steerNorm(p)-steerNorm(lap[k-1]))/h)
+			}
+		}
+	}
+	if start >= 0 {
+		flush(len(lap) - 1)
+	}
+	return out
+}
@@ -0,0 +1,128 @@
+package track
+
+// ThrottleStats summarises throttle use over one lap or one corner. Times are
+// seconds, distances meters along the master.
+type ThrottleStats struct {
+	Lap         int     `json:"lap,omitempty"`
+	Time        float64 `json:"time"`
+	FullTime    float64 `json:"fullTime"`
+	FullDist    float64 `json:"fullDist"`
+	PartialTime float64 `json:"partialTime"`
+	PartialDist float64 `json:"partialDist"`
+	CoastTime   float64 `json:"coastTime"` // neither throttle nor brake
+	CoastDist   float64 `json:"coastDist"`
+	BrakeTime   float64 `json:"brakeTime"`
+	Lifts       int     `json:"lifts,omitempty"`       // lift-offs on straights
+	Coasts      int     `json:"coasts,omitempty"`      // coasting intervals
+	PickupDelay float64 `json:"pickupDelay,omitempty"` // corners: minimum speed -> throttle pickup (s)
+}
+
+// ThrottleInterval is a stretch of a lap (sample indices, inclusive) flagged as
+// coasting or as a lift-off on a straight.
+type ThrottleInterval struct {
+	Type  string // "coasting" or "lift"
+	Start int
+	End   int
+}
+
+const (
+	throttleOff     = 0.05 // throttle (and brake) below this is off the pedal
+	throttleLift    = 0.8  // dropping below this after full throttle is a lift
+	coastMinTime    = 0.5  // seconds of coasting before it is reported
+	liftMinTime     = 0.1  // seconds a lift must last
+	throttleMinMove = 5.0  // speed (sample units) below which coasting is ignored
+)
+
+// HasPedals reports whether the lap carries throttle or brake pedal channels.
+// Throttle analysis is meaningless on values estimated from acceleration.
+func HasPedals(lap []PhaseSample) bool {
+	for _, p := range lap {
+		if p.Pedals {
+			return true
+		}
+	}
+	return false
+}
+
+// ThrottleUse accumulates time and distance at full, partial and no throttle between
+// master distances fromS and toS. lap must be ordered by RelS and carry pedal
+// channels (see HasPedals).
+func ThrottleUse(lap []PhaseSample, fromS, toS float64) ThrottleStats {
+	var st ThrottleStats
+	for k := 0; k+1 < len(lap); k++ {
+		p := lap[k]
+		if p.RelS < fromS || p.RelS >= toS {
+			continue
+		}
+		dt := lap[k+1].Time - p.Time
+		ds := lap[k+1].RelS - p.RelS
+		if dt <= 0 || dt > 1 {
+			continue
+		}
+		st.Time += dt
+		switch {
+		case p.Brake >= throttleOff:
+			st.BrakeTime += dt
+		case p.Throttle >= phaseThrottleMax:
+			st.FullTime += dt
+			st.FullDist += ds
+		case p.Throttle >= throttleOff:
+			st.PartialTime += dt
+			st.PartialDist += ds
+		default:
+			st.CoastTime += dt
+			st.CoastDist += ds
+		}
+	}
+	return st
+}
+
+// DetectThrottleIntervals finds coasting anywhere on the lap and lift-offs on the
+// given straights: throttle falling from full to below throttleLift and coming back
+// without the brake being touched. A lift that ends on the brake is the normal
+// approach to a braking zone and is not reported.
+func DetectThrottleIntervals(lap []PhaseSample, straights []SegmentDef) []ThrottleInterval {
+	onStraight := func(s float64) bool {
+		for _, seg := range straights {
+			if seg.Type == "straight" && s >= seg.StartS && s <= seg.EndS {
+				return true
+			}
+		}
+		return false
+	}
+	var out []ThrottleInterval
+	coastStart, liftStart := -1, -1
+	wasFull := false
+	for k, p := range lap {
+		coasting := p.Throttle < throttleOff && p.Brake < throttleOff && p.Speed >= throttleMinMove
+		if coasting && coastStart < 0 {
+			coastStart = k
+		}
+		if !coasting && coastStart >= 0 {
+			if lap[k-1].Time-lap[coastStart].Time >= coastMinTime {
+				out = append(out, ThrottleInterval{Type: "coasting", Start: coastStart, End: k - 1})
+			}
+			coastStart = -1
+		}
+
+		switch {
+		case p.Brake >= throttleOff || !onStraight(p.RelS):
+			liftStart = -1
+			wasFull = false
+		case p.Throttle >= phaseThrottleMax:
+			// A lift long enough to count as coasting is only reported once.
+			coasted := len(out) > 0 && out[len(out)-1].Type == "coasting" && out[len(out)-1].Start >= liftStart
+			if liftStart >= 0 && !coasted && lap[k-1].Time-lap[liftStart].Time >= liftMinTime {
+				out = append(out, ThrottleInterval{Type: "lift", Start: liftStart, End: k - 1})
+			}
+			liftStart = -1
+			wasFull = true
+		case wasFull && p.Throttle < throttleLift && liftStart < 0:
+			liftStart = k
+		}
+	}
+	if coastStart >= 0 && lap[len(lap)-1].Time-lap[coastStart].Time >= coastMinTime {
+		out = append(out, ThrottleInterval{Type: "coasting", Start: coastStart, End: len(lap) - 1})
+	}
+	return out
+}
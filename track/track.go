@@ -8,7 +8,10 @@ import (
 
 // --- MASTER LAP BUILDING ---
 
-// BuildMasterLap v2: resample each lap, align to lap 1, then average.
+// BuildMasterLap v3: resample each lap, align every lap to a seed lap by cyclic
+// cross-correlation, weight laps by how far they stray from the pointwise median,
+// then average. Heading uses a circular mean and each master point carries the
+// weighted spread (std dev, meters) of the laps around it.
 // lapIdx should contain start indices for each lap, with a final boundary
 // equal to len(points) (end-exclusive).
 func BuildMasterLap(points []models.Trackpoint, lapIdx []int, samples int) []models.Trackpoint {
@@ -41,25 +44,268 @@ func BuildMasterLap(points []models.Trackpoint, lapIdx []int, samples int) []mod
 		return nil
 	}
 
-	// 2) Average laps in world space (no rotation/scale).
+	// 2) Align each lap to the seed (the lap closest to the median length) so that
+	// index i refers to the same place on track in every lap. Alternate cyclic
+	// shift and translation (ICP-style) since sessions are re-based to their own origin.
+	seed := seedLap(laps)
+	for l := range laps {
+		if l == seed {
+			continue
+		}
+		for iter := 0; iter < 2; iter++ {
+			laps[l] = shiftClosedLap(laps[l], bestCyclicShift(laps[seed], laps[l]))
+			laps[l] = translateToRef(laps[seed], laps[l])
+		}
+	}
+
+	return averageLaps(laps)
+}
+
+// averageLaps combines index-aligned, equally sampled laps: laps are weighted by RMS
+// deviation from the pointwise median, positions are averaged in world space, heading
+// uses a circular mean and Spread records the weighted std dev around each point.
+func averageLaps(laps [][]models.Trackpoint) []models.Trackpoint {
+	weights := lapWeights(laps)
+	samples := len(laps[0])
 	master := make([]models.Trackpoint, samples)
 	for i := 0; i < samples; i++ {
-		var sx, sy, st float64
-		for l := 0; l < len(laps); l++ {
-			sx += laps[l][i].X
-			sy += laps[l][i].Y
-			st += laps[l][i].Theta
+		var sx, sy, ss, sc, sw float64
+		for l := range laps {
+			w := weights[l]
+			sx += w * laps[l][i].X
+			sy += w * laps[l][i].Y
+			ss += w * math.Sin(laps[l][i].Theta)
+			sc += w * math.Cos(laps[l][i].Theta)
+			sw += w
+		}
+		mx, my := sx/sw, sy/sw
+		var sd float64
+		for l := range laps {
+			dx := laps[l][i].X - mx
+			dy := laps[l][i].Y - my
+			sd += weights[l] * (dx*dx + dy*dy)
 		}
-		n := float64(len(laps))
 		master[i] = models.Trackpoint{
-			S:     laps[0][i].S, // local distance along lap
-			X:     sx / n,
-			Y:     sy / n,
-			Theta: st / n,
+			X:      mx,
+			Y:      my,
+			Theta:  math.Atan2(ss, sc),
+			Spread: math.Sqrt(sd / sw),
+		}
+	}
+
+	return RecomputeArcLength(master)
+}
+
+// seedLap picks the lap whose length is closest to the median lap length.
+func seedLap(laps [][]models.Trackpoint) int {
+	lens := make([]float64, len(laps))
+	for i, l := range laps {
+		lens[i] = l[len(l)-1].S
+	}
+	med := median(lens)
+	best := 0
+	for i, v := range lens {
+		if math.Abs(v-med) < math.Abs(lens[best]-med) {
+			best = i
+		}
+	}
+	return best
+}
+
+// bestCyclicShift finds the index shift k (within ±10% of the lap) that minimises the
+// squared distance between ref[i] and lap[i+k]. Laps are closed, so the last point
+// duplicates the first and indices wrap over len-1. A coarse pass is refined locally.
+func bestCyclicShift(ref, lap []models.Trackpoint) int {
+	n := len(ref) - 1
+	if n < 2 || len(lap) != len(ref) {
+		return 0
+	}
+	window := n / 10
+	cost := func(k, step int) float64 {
+		var sum float64
+		for i := 0; i < n; i += step {
+			j := ((i+k)%n + n) % n
+			dx := lap[j].X - ref[i].X
+			dy := lap[j].Y - ref[i].Y
+			sum += dx*dx + dy*dy
+		}
+		return sum
+	}
+	const coarse = 8
+	best, bestCost := 0, cost(0, coarse)
+	for k := -window; k <= window; k += coarse {
+		if c := cost(k, coarse); c < bestCost {
+			best, bestCost = k, c
+		}
+	}
+	center := best
+	bestCost = cost(center, 1)
+	for k := center - coarse; k <= center+coarse; k++ {
+		if c := cost(k, 1); c < bestCost {
+			best, bestCost = k, c
+		}
+	}
+	return best
+}
+
+// shiftClosedLap rotates a closed lap so that out[i] = lap[i+k], re-closing the loop.
+func shiftClosedLap(lap []models.Trackpoint, k int) []models.Trackpoint {
+	n := len(lap) - 1
+	if k == 0 || n < 2 {
+		return lap
+	}
+	out := make([]models.Trackpoint, len(lap))
+	for i := 0; i < n; i++ {
+		out[i] = lap[((i+k)%n+n)%n]
+	}
+	out[n] = out[0]
+	return RecomputeArcLength(out)
+}
+
+// translateToRef moves lap by the mean offset to ref over index-matched points.
+func translateToRef(ref, lap []models.Trackpoint) []models.Trackpoint {
+	if len(lap) != len(ref) || len(lap) == 0 {
+		return lap
+	}
+	var dx, dy float64
+	for i := range lap {
+		dx += ref[i].X - lap[i].X
+		dy += ref[i].Y - lap[i].Y
+	}
+	return Translate(lap, dx/float64(len(lap)), dy/float64(len(lap)))
+}
+
+// Translate returns a copy of points shifted by (dx, dy).
+func Translate(points []models.Trackpoint, dx, dy float64) []models.Trackpoint {
+	out := make([]models.Trackpoint, len(points))
+	for i, p := range points {
+		p.X += dx
+		p.Y += dy
+		out[i] = p
+	}
+	return out
+}
+
+// lapWeights down-weights laps by their RMS distance from the pointwise median lap
+// (Cauchy weighting) and rejects laps that stray far beyond the typical deviation.
+func lapWeights(laps [][]models.Trackpoint) []float64 {
+	weights := make([]float64, len(laps))
+	for i := range weights {
+		weights[i] = 1
+	}
+	if len(laps) < 3 {
+		return weights
+	}
+	n := len(laps[0])
+	xs := make([]float64, len(laps))
+	ys := make([]float64, len(laps))
+	sq := make([]float64, len(laps))
+	for i := 0; i < n; i++ {
+		for l := range laps {
+			xs[l] = laps[l][i].X
+			ys[l] = laps[l][i].Y
+		}
+		mx, my := median(xs), median(ys)
+		for l := range laps {
+			dx := laps[l][i].X - mx
+			dy := laps[l][i].Y - my
+			sq[l] += dx*dx + dy*dy
+		}
+	}
+	rms := make([]float64, len(laps))
+	for l := range laps {
+		rms[l] = math.Sqrt(sq[l] / float64(n))
+	}
+	medRMS := median(rms)
+	const minScale = 1.0 // meters; avoid over-penalising near-identical laps
+	const rejectAt = 5.0 // meters; never reject laps closer than this
+	scale := math.Max(2*medRMS, minScale)
+	kept := 0
+	for l, r := range rms {
+		if r > math.Max(4*medRMS, rejectAt) {
+			weights[l] = 0
+			continue
+		}
+		weights[l] = 1 / (1 + (r/scale)*(r/scale))
+		kept++
+	}
+	if kept == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return weights
+}
+
+// BuildMasterSprint builds a master path from several runs of the same point-to-point
+// route. Runs must share a world frame. Each run is trimmed to a common start and
+// finish gate (the latest start and earliest finish along the seed run), resampled
+// separately and then averaged like laps, so runs never wrap back to the start.
+func BuildMasterSprint(runs [][]models.Trackpoint, samples int) []models.Trackpoint {
+	var prepared [][]models.Trackpoint
+	for _, r := range runs {
+		if len(r) >= 2 {
+			prepared = append(prepared, RecomputeArcLength(r))
+		}
+	}
+	if len(prepared) == 0 || samples < 2 {
+		return nil
+	}
+	seed := seedLap(prepared)
+	if len(prepared) == 1 {
+		return BuildMasterPath(prepared[seed], samples, false)
+	}
+	ref := prepared[seed]
+
+	// Common gates expressed as indices on the seed run.
+	startIdx, endIdx := 0, len(ref)-1
+	for _, r := range prepared {
+		if i := nearestIndex(ref, r[0].X, r[0].Y, 0, len(ref)); i > startIdx {
+			startIdx = i
+		}
+		if i := nearestIndex(ref, r[len(r)-1].X, r[len(r)-1].Y, 0, len(ref)); i < endIdx {
+			endIdx = i
+		}
+	}
+	if endIdx <= startIdx+1 {
+		return BuildMasterPath(ref, samples, false)
+	}
+	gateS, gateE := ref[startIdx], ref[endIdx]
+
+	var laps [][]models.Trackpoint
+	for _, r := range prepared {
+		half := len(r) / 2
+		lo := nearestIndex(r, gateS.X, gateS.Y, 0, half+1)
+		hi := nearestIndex(r, gateE.X, gateE.Y, half, len(r))
+		if hi <= lo+1 {
+			continue
+		}
+		trimmed := RecomputeArcLength(r[lo : hi+1])
+		if lap := resampleLap(trimmed, 0, len(trimmed), samples); lap != nil {
+			laps = append(laps, lap)
 		}
 	}
+	if len(laps) == 0 {
+		return BuildMasterPath(ref, samples, false)
+	}
+	return averageLaps(laps)
+}
 
-	return master
+// nearestIndex returns the index in points[from:to] closest to (x, y).
+func nearestIndex(points []models.Trackpoint, x, y float64, from, to int) int {
+	if to > len(points) {
+		to = len(points)
+	}
+	best := from
+	bestD := math.Inf(1)
+	for i := from; i < to; i++ {
+		dx := points[i].X - x
+		dy := points[i].Y - y
+		if d := dx*dx + dy*dy; d < bestD {
+			best, bestD = i, d
+		}
+	}
+	return best
 }
 
 // BuildMasterPath builds a master path for an open/sprint-style run.
@@ -121,7 +367,7 @@ func resampleLap(points []models.Trackpoint, start, end, samples int) []models.T
 			S:     targetLocal, // local S from 0..lapLen
 			X:     x,
 			Y:     y,
-			Theta: p1.Theta + t*(p2.Theta-p1.Theta),
+			Theta: p1.Theta + t*wrapAngle(p2.Theta-p1.Theta),
 		}
 	}
 
@@ -137,8 +383,7 @@ func BuildTrack(samples []models.Sample) ([]models.Trackpoint, error) {
 
 	// Re-base world coordinates so the first sample sits at origin; makes downstream
 	// math easier to read and avoids very large coordinates.
-	originX := cleanFloat(samples[0].PosX, 0)
-	originZ := cleanFloat(samples[0].PosZ, 0)
+	originX, originZ := TrackOrigin(samples)
 
 	prevX := samples[0].PosX - originX
 	prevZ := samples[0].PosZ - originZ
@@ -176,6 +421,15 @@ func BuildTrack(samples []models.Sample) ([]models.Trackpoint, error) {
 	return track, nil
 }
 
+// TrackOrigin returns the world position BuildTrack subtracts from every point, so
+// tracks from different sessions can be moved back into a shared frame.
+func TrackOrigin(samples []models.Sample) (float64, float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	return cleanFloat(samples[0].PosX, 0), cleanFloat(samples[0].PosZ, 0)
+}
+
 func clamp(v, min, max float64) float64 {
 	if v < min {
 		return min
@@ -215,19 +469,31 @@ func DetectLapsNearStart(points []models.Trackpoint, radius float64, minLapDista
 	if len(points) == 0 {
 		return nil
 	}
+	return DetectLapsNearPoint(points, points[0].X, points[0].Y, radius, minLapDistance)
+}
 
-	startX := points[0].X
-	startY := points[0].Y
+// DetectLapsNearPoint is DetectLapsNearStart for an arbitrary start/finish point,
+// e.g. a stored gate. Index 0 always opens the first (possibly out-) lap.
+func DetectLapsNearPoint(points []models.Trackpoint, startX, startY float64, radius float64, minLapDistance float64) []int {
+	if len(points) == 0 {
+		return nil
+	}
 
 	r2 := radius * radius
 
 	indices := []int{0}
 	lastS := points[0].S
+	// When the session starts away from the point, its first pass opens lap 2
+	// regardless of distance travelled.
+	dx0 := points[0].X - startX
+	dy0 := points[0].Y - startY
+	firstFree := dx0*dx0+dy0*dy0 > r2
 
 	for i := 1; i < len(points); i++ {
 		dx := points[i].X - startX
 		dy := points[i].Y - startY
-		if dx*dx+dy*dy <= r2 && points[i].S-lastS >= minLapDistance {
+		far := points[i].S-lastS >= minLapDistance || (firstFree && len(indices) == 1)
+		if dx*dx+dy*dy <= r2 && far {
 			indices = append(indices, i)
 			lastS = points[i].S
 		}
@@ -0,0 +1,79 @@
+package track
+
+import "forza/models"
+
+// Gate is a timing line on the master track in world coordinates.
+type Gate struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Heading float64 `json:"heading"`
+	Radius  float64 `json:"radius"`
+}
+
+// TrackDefPoint is a master point as stored in a track definition.
+type TrackDefPoint struct {
+	S      float64 `json:"relS"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Theta  float64 `json:"theta"`
+	Spread float64 `json:"spread,omitempty"`
+}
+
+// TrackDef is a canonical, persistable track definition. Storing it keeps corner
+// numbering, segments, sector gates and relS comparable across sessions. Master
+// points and gates are world coordinates (not re-based to a session origin).
+type TrackDef struct {
+	Name        string           `json:"name,omitempty"`
+	Sprint      bool             `json:"sprint,omitempty"`
+	Master      []TrackDefPoint  `json:"master"`
+	Corners     []CornerDef      `json:"corners,omitempty"`
+	Segments    []SegmentDef     `json:"segments,omitempty"`
+	Sectors     []float64        `json:"sectors,omitempty"` // gate relS including 0 and lap end
+	StartFinish Gate             `json:"startFinish"`
+	Fingerprint *Fingerprint     `json:"fingerprint,omitempty"`
+	Match       *MatchThresholds `json:"match,omitempty"`      // identification thresholds for this track
+	SpeedTraps  []SpeedTrap      `json:"speedTraps,omitempty"` // user-defined traps and zones
+}
+
+// NewTrackDef captures a master track built in the frame offset by (frameX, frameY)
+// from world coordinates.
+func NewTrackDef(master []models.Trackpoint, corners []CornerDef, segments []SegmentDef, sectors []float64, sprint bool, frameX, frameY, gateRadius float64) TrackDef {
+	def := TrackDef{
+		Sprint:   sprint,
+		Corners:  corners,
+		Segments: segments,
+		Sectors:  sectors,
+	}
+	for _, p := range master {
+		def.Master = append(def.Master, TrackDefPoint{S: p.S, X: p.X + frameX, Y: p.Y + frameY, Theta: p.Theta, Spread: p.Spread})
+	}
+	if len(master) > 0 {
+		def.StartFinish = Gate{X: master[0].X + frameX, Y: master[0].Y + frameY, Heading: master[0].Theta, Radius: gateRadius}
+	}
+	fp := FingerprintOf(def.MasterPoints(0, 0), corners, sprint)
+	def.Fingerprint = &fp
+	return def
+}
+
+// MasterPoints returns the stored master moved into the frame offset by (frameX, frameY).
+func (d TrackDef) MasterPoints(frameX, frameY float64) []models.Trackpoint {
+	out := make([]models.Trackpoint, len(d.Master))
+	for i, p := range d.Master {
+		out[i] = models.Trackpoint{S: p.S, X: p.X - frameX, Y: p.Y - frameY, Theta: p.Theta, Spread: p.Spread}
+	}
+	return out
+}
+
+// EqualSectorGates splits the master into n equal-distance sectors and returns the
+// n+1 gate positions (relS), starting at 0 and ending at the master length.
+func EqualSectorGates(master []models.Trackpoint, n int) []float64 {
+	if len(master) == 0 || n < 1 {
+		return nil
+	}
+	total := master[len(master)-1].S
+	gates := make([]float64, n+1)
+	for i := range gates {
+		gates[i] = total * float64(i) / float64(n)
+	}
+	return gates
+}
@@ -0,0 +1,169 @@
+package track
+
+import (
+	"fmt"
+	"forza/models"
+	"math"
+	"sort"
+)
+
+// WheelNames orders the four wheels as the telemetry does.
+var WheelNames = [4]string{"FL", "FR", "RL", "RR"}
+
+// Wheel slip thresholds. With wheel rotation and a fitted tyre radius, slip is the
+// ratio of wheel surface speed to car speed minus one (-1 = locked). Without wheel
+// rotation the game's normalised TireSlip is used, where |slip| > 1 is past the limit.
+const (
+	wheelMinSpeed    = 5.0  // m/s
+	wheelMinTime     = 0.1  // seconds an interval must last
+	wheelBrakeOn     = 0.1  // brake pedal (0..1) for lock-ups
+	wheelThrottleOn  = 0.2  // throttle pedal (0..1) for wheelspin
+	lockRatio        = -0.2 // ratio slip below this under braking is a lock-up
+	spinRatio        = 0.2  // ratio slip above this under throttle is wheelspin
+	tireSlipLimit    = 1.0  // |TireSlip| beyond this when wheel rotation is missing
+	radiusMaxSlip    = 0.1  // |TireSlip| below this counts as rolling freely
+	radiusMinSamples = 20
+)
+
+// WheelSlipInterval is a lock-up or wheelspin on one wheel, as sample indices
+// (inclusive). Peak is the worst slip reached (ratio, or TireSlip when Ratio is false).
+type WheelSlipInterval struct {
+	Type     string // "lockup" or "wheelspin"
+	Wheel    string
+	Start    int
+	End      int
+	Peak     float64
+	Ratio    bool
+	Severity string // light, moderate or severe
+}
+
+func wheelRot(s models.Sample, w int) float64 {
+	return [4]float64{s.WheelRotFL, s.WheelRotFR, s.WheelRotRL, s.WheelRotRR}[w]
+}
+
+func wheelTireSlip(s models.Sample, w int) float64 {
+	return [4]float64{s.TireSlipFL, s.TireSlipFR, s.TireSlipRL, s.TireSlipRR}[w]
+}
+
+// TireRadii estimates each wheel's rolling radius (m) as the median of speed over
+// wheel angular speed while the tyre rolls freely. Zero when it cannot be estimated.
+func TireRadii(samples []models.Sample) [4]float64 {
+	var radii [4]float64
+	for w := range radii {
+		var vals []float64
+		for _, s := range samples {
+			v := speedMPS(s)
+			rot := math.Abs(wheelRot(s, w))
+			if v < wheelMinSpeed || rot < 1 || math.Abs(wheelTireSlip(s, w)) > radiusMaxSlip || s.Brake > 0 {
+				continue
+			}
+			vals = append(vals, v/rot)
+		}
+		if len(vals) >= radiusMinSamples {
+			sort.Float64s(vals)
+			radii[w] = vals[len(vals)/2]
+		}
+	}
+	return radii
+}
+
+// DetectWheelSlip finds per-wheel lock-ups under braking and wheelspin under throttle.
+func DetectWheelSlip(samples []models.Sample) []WheelSlipInterval {
+	radii := TireRadii(samples)
+	var out []WheelSlipInterval
+	for w, name := range WheelNames {
+		cur := WheelSlipInterval{Start: -1}
+		flush := func(end int) {
+			if cur.Start >= 0 && samples[end].Time-samples[cur.Start].Time >= wheelMinTime {
+				cur.End = end
+				cur.Severity = slipSeverity(cur.Peak, cur.Ratio)
+				out = append(out, cur)
+			}
+			cur = WheelSlipInterval{Start: -1}
+		}
+		for i, s := range samples {
+			kind, slip, ratio := "", 0.0, false
+			v := speedMPS(s)
+			braking := s.HasInputBrake && float64(s.Brake)/255 >= wheelBrakeOn
+			throttle := s.HasInputAccel && float64(s.ThrottleRaw)/255 >= wheelThrottleOn
+			if v >= wheelMinSpeed && (braking || throttle) {
+				if radii[w] > 0 {
+					ratio = true
+					slip = math.Abs(wheelRot(s, w))*radii[w]/v - 1
+					switch {
+					case braking && slip <= lockRatio:
+						kind = "lockup"
+					case throttle && !braking && slip >= spinRatio:
+						kind = "wheelspin"
+					}
+				} else if ts := wheelTireSlip(s, w); math.Abs(ts) >= tireSlipLimit {
+					slip = ts
+					if braking {
+						kind = "lockup"
+					} else {
+						kind = "wheelspin"
+					}
+				}
+			}
+			if kind != cur.Type && cur.Start >= 0 {
+				flush(i - 1)
+			}
+			if kind == "" {
+				continue
+			}
+			if cur.Start < 0 {
+				cur = WheelSlipInterval{Type: kind, Wheel: name, Start: i, Ratio: ratio}
+			}
+			if math.Abs(slip) > math.Abs(cur.Peak) {
+				cur.Peak = slip
+			}
+		}
+		if cur.Start >= 0 {
+			flush(len(samples) - 1)
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Start < out[b].Start })
+	return out
+}
+
+func slipSeverity(peak float64, ratio bool) string {
+	p := math.Abs(peak)
+	if !ratio {
+		// TireSlip beyond the limit: map 1.5 and 2.5 onto the slip-ratio thresholds
+		// below, so 1..1.5 is light, 1.5..2.5 moderate and beyond severe.
+		p = (p - 0.1) / 4
+	}
+	switch {
+	case p < 0.35:
+		return "light"
+	case p < 0.6:
+		return "moderate"
+	}
+	return "severe"
+}
+
+// WheelSlipEvents turns intervals into events carrying the wheel and severity.
+func WheelSlipEvents(samples []models.Sample, intervals []WheelSlipInterval) []models.Event {
+	events := make([]models.Event, 0, len(intervals))
+	for _, iv := range intervals {
+		dur := samples[iv.End].Time - samples[iv.Start].Time
+		peak := fmt.Sprintf("slip %.2f", iv.Peak)
+		if iv.Ratio {
+			peak = fmt.Sprintf("slip %+.0f%%", iv.Peak*100)
+		}
+		what := "lock-up"
+		if iv.Type == "wheelspin" {
+			what = "wheelspin"
+		}
+		events = append(events, models.Event{
+			Index:    iv.Start,
+			Time:     samples[iv.Start].Time,
+			Type:     iv.Type,
+			Note:     fmt.Sprintf("%s %s %.1fs, %s (%s)", iv.Wheel, what, dur, iv.Severity, peak),
+			Duration: dur,
+			Wheel:    iv.Wheel,
+			Severity: iv.Severity,
+		})
+	}
+	return events
+}